@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// The free space check runs before anything is extracted, so archive sizes are read from headers
+// without decompressing. Rar headers are walked past the packed data, gzip and xz record their own
+// uncompressed size at the end. Archives that don't say how big they are get an estimate instead.
+
+// archives without a readable size are counted as this many times their own size
+const ARCHIVE_SIZE_ESTIMATE = 4
+
+const RAR5_SIGNATURE = "Rar!\x1a\x07\x01\x00"
+const RAR4_SIGNATURE = "Rar!\x1a\x07\x00"
+
+var errRarEncryptedHeaders = errors.New("rar headers are encrypted")
+
+// RarHeaderSizes are the sizes found in the file headers of a rar archive
+type RarHeaderSizes struct {
+	Size    int64
+	Unknown []string // files that don't record their unpacked size, not counted in Size
+}
+
+func (sizes *RarHeaderSizes) add(name string, isDir bool, first bool, unknown bool, size int64, include func(name string) bool) {
+	// split files record their whole size in the first block
+	if isDir || !first || !includedEntry(include, name) {
+		return
+	}
+	if unknown || size < 0 {
+		sizes.Unknown = append(sizes.Unknown, name)
+		return
+	}
+	sizes.Size += size
+}
+
+// ReadRarHeaderSizes adds up the unpacked sizes in the file headers of a rar archive
+func ReadRarHeaderSizes(archivePath string, include func(name string) bool) (RarHeaderSizes, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return RarHeaderSizes{}, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	signature, err := reader.Peek(len(RAR5_SIGNATURE))
+	if err != nil {
+		return RarHeaderSizes{}, err
+	}
+	if string(signature) == RAR5_SIGNATURE {
+		reader.Discard(len(RAR5_SIGNATURE))
+		return readRar5Sizes(reader, include)
+	}
+	if string(signature[:len(RAR4_SIGNATURE)]) == RAR4_SIGNATURE {
+		reader.Discard(len(RAR4_SIGNATURE))
+		return readRar4Sizes(reader, include)
+	}
+	return RarHeaderSizes{}, fmt.Errorf("%s is not a rar archive", archivePath)
+}
+
+// rarVint reads the variable length numbers of rar 5 headers
+func rarVint(data []byte) (uint64, []byte, error) {
+	value, count := binary.Uvarint(data)
+	if count <= 0 {
+		return 0, data, errors.New("corrupt rar header")
+	}
+	return value, data[count:], nil
+}
+
+func readRar5Sizes(reader *bufio.Reader, include func(name string) bool) (RarHeaderSizes, error) {
+	sizes := RarHeaderSizes{}
+	for {
+		// header crc, then the size of the rest of the header
+		if _, err := reader.Discard(4); err == io.EOF {
+			return sizes, nil
+		} else if err != nil {
+			return sizes, err
+		}
+		headerSize, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return sizes, err
+		}
+		header := make([]byte, headerSize)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return sizes, err
+		}
+
+		var flags, dataSize uint64
+		blockType, data, err := rarVint(header)
+		if err != nil {
+			return sizes, err
+		}
+		if flags, data, err = rarVint(data); err != nil {
+			return sizes, err
+		}
+		if flags&0x0001 != 0 { // extra area
+			if _, data, err = rarVint(data); err != nil {
+				return sizes, err
+			}
+		}
+		if flags&0x0002 != 0 { // data area
+			if dataSize, data, err = rarVint(data); err != nil {
+				return sizes, err
+			}
+		}
+
+		switch blockType {
+		case 2: // file
+			var fileFlags, unpackedSize uint64
+			if fileFlags, data, err = rarVint(data); err != nil {
+				return sizes, err
+			}
+			if unpackedSize, data, err = rarVint(data); err != nil {
+				return sizes, err
+			}
+			if _, data, err = rarVint(data); err != nil { // attributes
+				return sizes, err
+			}
+			skip := 0
+			if fileFlags&0x0002 != 0 { // modification time
+				skip += 4
+			}
+			if fileFlags&0x0004 != 0 { // crc32
+				skip += 4
+			}
+			if len(data) < skip {
+				return sizes, errors.New("corrupt rar header")
+			}
+			data = data[skip:]
+			for i := 0; i < 2; i++ { // compression and host os
+				if _, data, err = rarVint(data); err != nil {
+					return sizes, err
+				}
+			}
+			var nameLength uint64
+			if nameLength, data, err = rarVint(data); err != nil {
+				return sizes, err
+			}
+			if uint64(len(data)) < nameLength {
+				return sizes, errors.New("corrupt rar header")
+			}
+			name := string(data[:nameLength])
+			sizes.add(name, fileFlags&0x0001 != 0, flags&0x0008 == 0, fileFlags&0x0008 != 0, int64(unpackedSize), include)
+		case 4:
+			return sizes, errRarEncryptedHeaders
+		case 5: // end of archive
+			return sizes, nil
+		}
+		if _, err := reader.Discard(int(dataSize)); err != nil {
+			return sizes, err
+		}
+	}
+}
+
+func readRar4Sizes(reader *bufio.Reader, include func(name string) bool) (RarHeaderSizes, error) {
+	sizes := RarHeaderSizes{}
+	for {
+		// crc, type, flags and size of the header
+		block := make([]byte, 7)
+		if _, err := io.ReadFull(reader, block); err == io.EOF {
+			return sizes, nil
+		} else if err != nil {
+			return sizes, err
+		}
+		blockType := block[2]
+		flags := binary.LittleEndian.Uint16(block[3:5])
+		headerSize := binary.LittleEndian.Uint16(block[5:7])
+		if headerSize < 7 {
+			return sizes, errors.New("corrupt rar header")
+		}
+		data := make([]byte, headerSize-7)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return sizes, err
+		}
+		dataSize := int64(0)
+		if flags&0x8000 != 0 {
+			if len(data) < 4 {
+				return sizes, errors.New("corrupt rar header")
+			}
+			dataSize = int64(binary.LittleEndian.Uint32(data))
+		}
+		largeFile := (blockType == 0x74 || blockType == 0x7a) && flags&0x0100 != 0
+		if largeFile {
+			if len(data) < 33 {
+				return sizes, errors.New("corrupt rar header")
+			}
+			dataSize |= int64(binary.LittleEndian.Uint32(data[25:29])) << 32
+		}
+
+		switch blockType {
+		case 0x73: // archive
+			if flags&0x0080 != 0 {
+				return sizes, errRarEncryptedHeaders
+			}
+		case 0x74: // file
+			if len(data) < 25 {
+				return sizes, errors.New("corrupt rar header")
+			}
+			unpackedSize := int64(binary.LittleEndian.Uint32(data[4:8]))
+			unknown := unpackedSize == 0xffffffff
+			nameStart := 25
+			if largeFile {
+				high := binary.LittleEndian.Uint32(data[29:33])
+				unpackedSize |= int64(high) << 32
+				unknown = unknown && high == 0xffffffff
+				nameStart = 33
+			}
+			nameLength := int(binary.LittleEndian.Uint16(data[19:21]))
+			if len(data) < nameStart+nameLength {
+				return sizes, errors.New("corrupt rar header")
+			}
+			name := string(data[nameStart : nameStart+nameLength])
+			if flags&0x0200 != 0 {
+				name = decodeRarName(data[nameStart : nameStart+nameLength])
+			}
+			name = strings.ReplaceAll(name, "\\", "/")
+			sizes.add(name, flags&0x00e0 == 0x00e0, flags&0x0001 == 0, unknown, unpackedSize, include)
+		case 0x7b: // end of archive
+			return sizes, nil
+		}
+		if _, err := reader.Discard(int(dataSize)); err != nil {
+			return sizes, err
+		}
+	}
+}
+
+// decodeRarName reads the unicode names of rar 4 archives, stored as the ascii name followed by
+// the differences to it, the same way rardecode does
+func decodeRarName(buf []byte) string {
+	at := bytes.IndexByte(buf, 0)
+	if at < 0 {
+		return string(buf)
+	}
+	name := buf[:at]
+	encoded := buf[at+1:]
+	if len(encoded) < 2 {
+		return string(name)
+	}
+	next := func() byte {
+		value := encoded[0]
+		encoded = encoded[1:]
+		return value
+	}
+	highByte := uint16(next()) << 8
+	flags := next()
+	flagBits := 8
+	chars := []uint16{}
+	for len(chars) < len(name) && len(encoded) > 0 {
+		if flagBits == 0 {
+			flags = next()
+			flagBits = 8
+			if len(encoded) == 0 {
+				break
+			}
+		}
+		switch flags >> 6 {
+		case 0:
+			chars = append(chars, uint16(next()))
+		case 1:
+			chars = append(chars, uint16(next())|highByte)
+		case 2:
+			if len(encoded) < 2 {
+				break
+			}
+			low := next()
+			chars = append(chars, uint16(low)|uint16(next())<<8)
+		case 3:
+			count := next()
+			ascii := name[len(chars):]
+			if length := int(count&0x7f) + 2; length < len(ascii) {
+				ascii = ascii[:length]
+			}
+			if count&0x80 != 0 {
+				if len(encoded) < 1 {
+					break
+				}
+				correction := next()
+				for _, char := range ascii {
+					chars = append(chars, uint16(char+correction)|highByte)
+				}
+			} else {
+				for _, char := range ascii {
+					chars = append(chars, uint16(char))
+				}
+			}
+		}
+		flags <<= 2
+		flagBits -= 2
+	}
+	return string(utf16.Decode(chars))
+}
+
+// gzipRecordedSize reads the uncompressed size gzip keeps in its last 4 bytes. It only holds the size
+// modulo 4 GiB, so bigger streams are not trusted.
+func gzipRecordedSize(file *os.File, fileSize int64) (int64, bool) {
+	if fileSize < 18 || fileSize >= 1<<32 {
+		return 0, false
+	}
+	trailer := make([]byte, 4)
+	if _, err := file.ReadAt(trailer, fileSize-4); err != nil {
+		return 0, false
+	}
+	size := int64(binary.LittleEndian.Uint32(trailer))
+	// compressed data is at most a little larger than what it holds
+	if size < fileSize/2 {
+		return 0, false
+	}
+	return size, true
+}
+
+// xzRecordedSize adds up the uncompressed sizes in the index at the end of an xz stream
+func xzRecordedSize(file *os.File, fileSize int64) (int64, bool) {
+	if fileSize < 32 {
+		return 0, false
+	}
+	footer := make([]byte, 12)
+	if _, err := file.ReadAt(footer, fileSize-12); err != nil || string(footer[10:]) != "YZ" {
+		return 0, false
+	}
+	indexSize := (int64(binary.LittleEndian.Uint32(footer[4:8])) + 1) * 4
+	if indexSize > fileSize-12 {
+		return 0, false
+	}
+	index := make([]byte, indexSize)
+	if _, err := file.ReadAt(index, fileSize-12-indexSize); err != nil || index[0] != 0 {
+		return 0, false
+	}
+	data := index[1:]
+	records, count := binary.Uvarint(data)
+	if count <= 0 {
+		return 0, false
+	}
+	data = data[count:]
+	size := int64(0)
+	for i := uint64(0); i < records; i++ {
+		// unpadded size, then uncompressed size
+		if _, count = binary.Uvarint(data); count <= 0 {
+			return 0, false
+		}
+		data = data[count:]
+		uncompressed, count := binary.Uvarint(data)
+		if count <= 0 {
+			return 0, false
+		}
+		data = data[count:]
+		size += int64(uncompressed)
+	}
+	return size, true
+}
+
+// estimatedSize is the guess for archives that don't say how big they are
+func estimatedSize(archivePath string) (int64, error) {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size() * ARCHIVE_SIZE_ESTIMATE, nil
+}
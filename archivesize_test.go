@@ -0,0 +1,193 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nwaples/rardecode"
+	"github.com/ulikunitz/xz"
+)
+
+type testRarFile struct {
+	Name    string
+	Data    []byte
+	IsDir   bool
+	Unknown bool
+}
+
+// rar4Block writes a rar 4 block, the crc covers everything after itself
+func rar4Block(buf *bytes.Buffer, blockType byte, flags uint16, fields []byte) {
+	header := &bytes.Buffer{}
+	header.WriteByte(blockType)
+	binary.Write(header, binary.LittleEndian, flags)
+	binary.Write(header, binary.LittleEndian, uint16(len(fields)+7))
+	header.Write(fields)
+	binary.Write(buf, binary.LittleEndian, uint16(crc32.ChecksumIEEE(header.Bytes())))
+	buf.Write(header.Bytes())
+}
+
+// writeTestRar4 writes a rar 4 archive with stored files, rar can't be written from Go otherwise
+func writeTestRar4(t *testing.T, path string, files []testRarFile) {
+	buf := &bytes.Buffer{}
+	buf.WriteString(RAR4_SIGNATURE)
+	rar4Block(buf, 0x73, 0, make([]byte, 6))
+	for _, file := range files {
+		flags := uint16(0x8000)
+		if file.IsDir {
+			flags |= 0x00e0
+		}
+		unpackedSize := uint32(len(file.Data))
+		if file.Unknown {
+			unpackedSize = 0xffffffff
+		}
+		fields := &bytes.Buffer{}
+		binary.Write(fields, binary.LittleEndian, uint32(len(file.Data)))
+		binary.Write(fields, binary.LittleEndian, unpackedSize)
+		fields.WriteByte(2) // windows
+		binary.Write(fields, binary.LittleEndian, crc32.ChecksumIEEE(file.Data))
+		binary.Write(fields, binary.LittleEndian, uint32(0))
+		fields.Write([]byte{20, 0x30}) // version, stored
+		binary.Write(fields, binary.LittleEndian, uint16(len(file.Name)))
+		binary.Write(fields, binary.LittleEndian, uint32(0))
+		fields.WriteString(file.Name)
+		rar4Block(buf, 0x74, flags, fields.Bytes())
+		buf.Write(file.Data)
+	}
+	rar4Block(buf, 0x7b, 0x4000, nil)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rardecodeSize is the size rardecode finds, by walking, and for solid archives decompressing, every file
+func rardecodeSize(t *testing.T, path string, include func(name string) bool) int64 {
+	size := int64(0)
+	err := RarExtractor{}.walk(path, func(header *rardecode.FileHeader, reader io.Reader) error {
+		if !header.IsDir && includedEntry(include, header.Name) {
+			size += header.UnPackedSize
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return size
+}
+
+func TestReadRar4HeaderSizes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mod.rar")
+	writeTestRar4(t, path, []testRarFile{
+		{Name: "Data Files", IsDir: true},
+		{Name: "Data Files\\mod.esp", Data: bytes.Repeat([]byte("esp"), 1000)},
+		{Name: "Data Files\\meshes\\a.nif", Data: bytes.Repeat([]byte("nif"), 500)},
+		{Name: "readme.txt", Data: []byte("readme")},
+	})
+	sizes, err := ReadRarHeaderSizes(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := rardecodeSize(t, path, nil); sizes.Size != want || sizes.Size != 4506 {
+		t.Errorf("size = %d, rardecode = %d, want 4506", sizes.Size, want)
+	}
+
+	meshes := archivePathFilter([]string{"data files/meshes"})
+	if sizes, _ := ReadRarHeaderSizes(path, meshes); sizes.Size != 1500 {
+		t.Errorf("size of meshes = %d, want 1500", sizes.Size)
+	}
+}
+
+func TestReadRar4HeaderSizesUnknown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mod.rar")
+	writeTestRar4(t, path, []testRarFile{
+		{Name: "a.esp", Data: []byte("known")},
+		{Name: "b.esp", Data: []byte("unknown"), Unknown: true},
+	})
+	sizes, err := ReadRarHeaderSizes(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sizes.Size != 5 || len(sizes.Unknown) != 1 || sizes.Unknown[0] != "b.esp" {
+		t.Errorf("sizes = %+v, want 5 and b.esp unknown", sizes)
+	}
+}
+
+// sample.rar is the rar 5 archive of the mholt/archiver test data
+func TestReadRar5HeaderSizes(t *testing.T) {
+	path := filepath.Join("testdata", "sample.rar")
+	sizes, err := ReadRarHeaderSizes(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := rardecodeSize(t, path, nil); sizes.Size != want || want == 0 {
+		t.Errorf("size = %d, rardecode = %d", sizes.Size, want)
+	}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	writer  io.Writer
+	written int64
+}
+
+func (counter *countingWriter) Write(data []byte) (int, error) {
+	count, err := counter.writer.Write(data)
+	counter.written += int64(count)
+	return count, err
+}
+
+func writeTestTar(t *testing.T, writer io.Writer) int64 {
+	counter := &countingWriter{writer: writer}
+	archive := tar.NewWriter(counter)
+	for _, name := range []string{"Data Files/mod.esp", "Data Files/meshes/a.nif"} {
+		data := bytes.Repeat([]byte(name), 2000)
+		archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))})
+		archive.Write(data)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return counter.written
+}
+
+func TestCompressedTarSize(t *testing.T) {
+	dir := t.TempDir()
+
+	gzipPath := filepath.Join(dir, "mod.tar.gz")
+	gzipFile, _ := os.Create(gzipPath)
+	gzipWriter := gzip.NewWriter(gzipFile)
+	tarSize := writeTestTar(t, gzipWriter)
+	gzipWriter.Close()
+	gzipFile.Close()
+	if size, err := (TarExtractor{}).Size(gzipPath, nil); err != nil || size != tarSize {
+		t.Errorf("gzip size = %d, %v, want %d", size, err, tarSize)
+	}
+
+	xzPath := filepath.Join(dir, "mod.tar.xz")
+	xzFile, _ := os.Create(xzPath)
+	xzWriter, err := xz.NewWriter(xzFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestTar(t, xzWriter)
+	xzWriter.Close()
+	xzFile.Close()
+	if size, err := (TarExtractor{}).Size(xzPath, nil); err != nil || size != tarSize {
+		t.Errorf("xz size = %d, %v, want %d", size, err, tarSize)
+	}
+
+	tarPath := filepath.Join(dir, "mod.tar")
+	tarFile, _ := os.Create(tarPath)
+	writeTestTar(t, tarFile)
+	tarFile.Close()
+	meshes := archivePathFilter([]string{"Data Files/meshes"})
+	if size, err := (TarExtractor{}).Size(tarPath, meshes); err != nil || size != int64(len("Data Files/meshes/a.nif")*2000) {
+		t.Errorf("tar size of meshes = %d, %v", size, err)
+	}
+}
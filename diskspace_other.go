@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// FreeDiskSpace is the space the current user can still write on the filesystem of an existing folder
+func FreeDiskSpace(folder string) (int64, error) {
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(folder, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// FreeDiskSpace is the space the current user can still write on the drive of an existing folder
+func FreeDiskSpace(folder string) (int64, error) {
+	path, err := syscall.UTF16PtrFromString(folder)
+	if err != nil {
+		return 0, err
+	}
+	var available, total, free uint64
+	result, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&available)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&free)))
+	if result == 0 {
+		return 0, err
+	}
+	return int64(available), nil
+}
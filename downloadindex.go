@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The download index records every archive any preset downloaded, so switching presets doesn't
+// download mods again. Records are keyed by mod id and the nexus file id, or the site file name
+// when there is no file id. The first time the index is read it is built from the preset manifests.
+
+const DOWNLOAD_INDEX = "download-index.yaml"
+
+type DownloadIndex struct {
+	Records []ManifestRecord `yaml:"records"`
+}
+
+func getDownloadIndexPath() string {
+	return fmt.Sprint("./", "manifests/", DOWNLOAD_INDEX)
+}
+
+func downloadIndexKey(modId int32, fileId int64, siteFileName string) string {
+	if fileId > 0 {
+		return fmt.Sprint(modId, "#", fileId)
+	}
+	return fmt.Sprint(modId, "/", siteFileName)
+}
+
+func (index *DownloadIndex) Add(record ManifestRecord) {
+	key := downloadIndexKey(record.ModId, record.FileId, record.FileDisplayName)
+	for i, indexed := range index.Records {
+		if downloadIndexKey(indexed.ModId, indexed.FileId, indexed.FileDisplayName) == key {
+			index.Records[i] = record
+			return
+		}
+	}
+	index.Records = append(index.Records, record)
+}
+
+// Find returns the indexed archive of a step, by file id when the step pins one
+func (index DownloadIndex) Find(step DownloadStep) (ManifestRecord, bool) {
+	for _, record := range index.Records {
+		if record.ModId != step.ModId {
+			continue
+		}
+		if step.FileId > 0 && record.FileId != step.FileId {
+			continue
+		}
+		if step.FileId == 0 && record.FileDisplayName != step.SiteFileName {
+			continue
+		}
+		if step.Version != "" && record.Version != "" && record.Version != step.Version {
+			continue
+		}
+		return record, true
+	}
+	return ManifestRecord{}, false
+}
+
+// ReadDownloadIndex reads the index, or builds it from the preset manifests when there is none yet
+func ReadDownloadIndex() DownloadIndex {
+	index := DownloadIndex{}
+	file, err := ioutil.ReadFile(getDownloadIndexPath())
+	if err == nil {
+		if err := yaml.Unmarshal(file, &index); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		return index
+	}
+	if !os.IsNotExist(err) {
+		fmt.Println(err.Error())
+	}
+
+	manifests, _ := filepath.Glob("./manifests/*-manifest.yaml")
+	for _, path := range manifests {
+		manifest := ReadManifest(filepath.Base(path))
+		for _, record := range manifest.Records {
+			index.Add(record)
+		}
+	}
+	return index
+}
+
+func WriteDownloadIndex(index DownloadIndex) {
+	data, err := yaml.Marshal(&index)
+	if err != nil {
+		log.Fatal(err)
+	}
+	checkError(os.MkdirAll("./manifests/", os.ModeDir|os.ModePerm))
+	if err := ioutil.WriteFile(getDownloadIndexPath(), data, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// IndexManifest adds the records of a manifest to the download index
+func IndexManifest(manifest *ManifestListConfig) {
+	index := ReadDownloadIndex()
+	for _, record := range manifest.Records {
+		index.Add(record)
+	}
+	WriteDownloadIndex(index)
+}
+
+// findIndexedDownload looks for an archive another preset downloaded for a step, that is
+// still in the downloads folder and matches the pins of the step
+func findIndexedDownload(index DownloadIndex, step DownloadStep, downloadPath string) (ManifestRecord, bool) {
+	record, ok := index.Find(step)
+	if !ok || strings.TrimSpace(record.FileName) == "" {
+		return record, false
+	}
+	archivePath := fmt.Sprint(downloadPath, "/", record.FileName)
+	if exists, _ := Exists(archivePath); !exists {
+		return record, false
+	}
+	if err := CheckArchive(archivePath, step, &record); err != nil {
+		fmt.Println(fmt.Sprint("Not reusing ", err))
+		return record, false
+	}
+	// the record keeps the name this preset gives the download
+	record.FileDisplayName = step.SiteFileName
+	return record, true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// inTempDir runs a test from an empty folder, manifests are read relative to the working directory
+func inTempDir(t *testing.T) string {
+	dir := t.TempDir()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+	return dir
+}
+
+func TestGetDownloadedModsReplacesMissingArchive(t *testing.T) {
+	dir := inTempDir(t)
+	downloads := filepath.Join(dir, "downloads")
+	os.MkdirAll(downloads, os.ModePerm)
+	os.WriteFile(filepath.Join(downloads, "Core-49231-2-0.zip"), []byte("core"), 0644)
+
+	step := DownloadStep{Type: NEXUS, ModId: 49231, SiteFileName: "Core"}
+	// this preset's record points at an archive that was deleted
+	WriteManifest(&ManifestListConfig{ListName: "own", Records: []ManifestRecord{
+		{FileName: "Core-49231-1-0.zip", ModId: 49231, FileDisplayName: "Core"},
+	}}, "own")
+	// another preset downloaded the file again
+	WriteDownloadIndex(DownloadIndex{Records: []ManifestRecord{
+		{FileName: "Core-49231-2-0.zip", ModId: 49231, FileDisplayName: "Core"},
+	}})
+
+	downloaded, manifest := GetDownloadedMods("own", downloads, []DownloadStep{step})
+	if len(downloaded) != 1 {
+		t.Errorf("downloaded = %v, want Core", downloaded)
+	}
+	if len(manifest.Records) != 1 || manifest.Records[0].FileName != "Core-49231-2-0.zip" {
+		t.Fatalf("records = %+v, want only the indexed archive", manifest.Records)
+	}
+	record := findFileManifestRecord(manifest.Records, []DownloadStep{step}, 49231, 0)
+	if record.FileName != "Core-49231-2-0.zip" {
+		t.Errorf("unpacking would use %s", record.FileName)
+	}
+}
+
+func TestGetDownloadedModsTellsStepsApart(t *testing.T) {
+	dir := inTempDir(t)
+	downloads := filepath.Join(dir, "downloads")
+	os.MkdirAll(downloads, os.ModePerm)
+	os.WriteFile(filepath.Join(downloads, "Main File-100-1-0.zip"), []byte("first"), 0644)
+
+	first := DownloadStep{Type: NEXUS, ModId: 100, SiteFileName: "Main File"}
+	second := DownloadStep{Type: NEXUS, ModId: 200, SiteFileName: "Main File"}
+	WriteManifest(&ManifestListConfig{ListName: "own", Records: []ManifestRecord{
+		{FileName: "Main File-100-1-0.zip", ModId: 100, FileDisplayName: "Main File"},
+	}}, "own")
+
+	downloaded, _ := GetDownloadedMods("own", downloads, []DownloadStep{first, second})
+	if !sliceContains(downloaded, downloadStepKey(first)) || sliceContains(downloaded, downloadStepKey(second)) {
+		t.Errorf("downloaded = %v, want only mod 100", downloaded)
+	}
+}
+
+func TestSetRecord(t *testing.T) {
+	manifest := ManifestListConfig{}
+	manifest.SetRecord(ManifestRecord{FileName: "a-1.zip", ModId: 1, FileDisplayName: "a"})
+	manifest.SetRecord(ManifestRecord{FileName: "a-2.zip", ModId: 2, FileDisplayName: "a"})
+	manifest.SetRecord(ManifestRecord{FileName: "a-1-new.zip", ModId: 1, FileDisplayName: "a"})
+	if len(manifest.Records) != 2 || manifest.Records[0].FileName != "a-1-new.zip" || manifest.Records[1].FileName != "a-2.zip" {
+		t.Errorf("records = %+v", manifest.Records)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+)
+
+// Missing downloads run through a queue of workers. Each worker has its own browser tab for
+// Nexus downloads, direct downloads share an http client. Downloads from the same host are
+// started at least downloadInterval apart, and the manifest is written after every finished file.
+
+const DEFAULT_PARALLEL_DOWNLOADS = 2
+const DEFAULT_DOWNLOAD_INTERVAL = 500 * time.Millisecond
+const PROGRESS_INTERVAL = 2 * time.Second
+const DOWNLOAD_ATTEMPTS = 3
+const RETRY_BACKOFF = 5 * time.Second
+
+// RateLimiter spaces out the start of downloads from one host
+type RateLimiter struct {
+	lock     sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func (limiter *RateLimiter) Wait() {
+	limiter.lock.Lock()
+	now := time.Now()
+	wait := limiter.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	limiter.next = now.Add(wait + limiter.interval)
+	limiter.lock.Unlock()
+	time.Sleep(wait)
+}
+
+type downloadProgress struct {
+	name     string
+	received int64
+	total    int64
+	started  time.Time
+}
+
+// ProgressTracker keeps the progress of every running download for the periodic report. Downloads
+// are keyed by their step, names like "Main File" repeat across mods.
+type ProgressTracker struct {
+	label    string // what is tracked, ie "Downloads"
+	lock     sync.Mutex
+	active   map[string]*downloadProgress
+	finished int
+	count    int
+}
+
+func NewProgressTracker(label string, count int) *ProgressTracker {
+	return &ProgressTracker{label: label, active: make(map[string]*downloadProgress), count: count}
+}
+
+func (tracker *ProgressTracker) Start(key string, name string) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.active[key] = &downloadProgress{name: name, started: time.Now()}
+}
+
+func (tracker *ProgressTracker) Update(key string, received int64, total int64) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	if progress, ok := tracker.active[key]; ok {
+		progress.received = received
+		progress.total = total
+	}
+}
+
+func (tracker *ProgressTracker) Finish(key string) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	delete(tracker.active, key)
+	tracker.finished++
+}
+
+// Report lists every running download with its progress and estimated time left
+func (tracker *ProgressTracker) Report() []string {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	keys := []string{}
+	for key := range tracker.active {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := []string{fmt.Sprintf("%s: %d of %d done", tracker.label, tracker.finished, tracker.count)}
+	for _, key := range keys {
+		progress := tracker.active[key]
+		name := progress.name
+		line := fmt.Sprintf("  %s: %s", name, formatBytes(progress.received))
+		if progress.total > 0 {
+			line = fmt.Sprintf("  %s: %d%% of %s", name, progress.received*100/progress.total, formatBytes(progress.total))
+			elapsed := time.Since(progress.started)
+			if progress.received > 0 && elapsed > 0 {
+				remaining := time.Duration(float64(elapsed) * float64(progress.total-progress.received) / float64(progress.received))
+				line = fmt.Sprint(line, ", ", remaining.Round(time.Second), " left")
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func formatBytes(size int64) string {
+	return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+}
+
+type DownloadQueue struct {
+	listName       string
+	downloadFolder string
+	workers        int
+	interval       time.Duration
+	nexusApi       *NexusApi
+	downloader     Downloader
+	progress       *ProgressTracker
+
+	limiterLock sync.Mutex
+	limiters    map[string]*RateLimiter
+
+	browserLock sync.Mutex
+	browser     *rod.Browser
+
+	profile SiteProfile // nexus site profile, read before the queue runs when nexus steps are missing
+
+	manifestLock sync.Mutex
+	manifest     *ManifestListConfig
+}
+
+func NewDownloadQueue(listName string, manifest *ManifestListConfig, prefs PreferencesConfig) *DownloadQueue {
+	workers := prefs.ParallelDownloads
+	if workers <= 0 {
+		workers = DEFAULT_PARALLEL_DOWNLOADS
+	}
+	interval := DEFAULT_DOWNLOAD_INTERVAL
+	if prefs.DownloadInterval > 0 {
+		interval = time.Duration(prefs.DownloadInterval) * time.Millisecond
+	}
+	return &DownloadQueue{
+		listName:       listName,
+		downloadFolder: prefs.Downloads,
+		workers:        workers,
+		interval:       interval,
+		nexusApi:       NewNexusApi(prefs),
+		downloader:     NewDownloader(),
+		limiters:       make(map[string]*RateLimiter),
+		manifest:       manifest,
+	}
+}
+
+// sourceHost is the host a step downloads from, local files have none
+func sourceHost(step DownloadStep) string {
+	switch step.Type {
+	case NEXUS:
+		return "nexusmods.com"
+	case GITHUB_RELEASE:
+		return "github.com"
+	case URL:
+		if parsed, err := url.Parse(step.Url); err == nil {
+			return parsed.Host
+		}
+	}
+	return ""
+}
+
+func (queue *DownloadQueue) waitForHost(step DownloadStep) {
+	host := sourceHost(step)
+	if host == "" {
+		return
+	}
+	queue.limiterLock.Lock()
+	limiter, ok := queue.limiters[host]
+	if !ok {
+		limiter = &RateLimiter{interval: queue.interval}
+		queue.limiters[host] = limiter
+	}
+	queue.limiterLock.Unlock()
+	limiter.Wait()
+}
+
+// newPage opens a tab for a worker, starting the browser the first time a tab is needed
+func (queue *DownloadQueue) newPage() *rod.Page {
+	queue.browserLock.Lock()
+	defer queue.browserLock.Unlock()
+	if queue.browser == nil {
+		queue.browser = createRodHandler(queue.downloadFolder)
+	}
+	return queue.browser.MustPage(NEXUS_MODS_URL)
+}
+
+func (queue *DownloadQueue) addRecord(step DownloadStep, file DownloadedFile) {
+	queue.manifestLock.Lock()
+	defer queue.manifestLock.Unlock()
+	queue.manifest.SetRecord(ManifestRecord{
+		FileName:        file.FileName,
+		ModId:           step.ModId,
+		FileDisplayName: step.SiteFileName,
+		FileId:          file.FileId,
+		Version:         file.Version,
+	})
+	WriteManifest(queue.manifest, queue.listName)
+}
+
+func (queue *DownloadQueue) download(step DownloadStep, page **rod.Page) (DownloadedFile, error) {
+	onProgress := func(received int64, total int64) {
+		queue.progress.Update(downloadStepKey(step), received, total)
+	}
+
+	if step.Type != NEXUS {
+		downloader := queue.downloader
+		downloader.OnProgress = onProgress
+		return downloader.DownloadFromSource(step, queue.downloadFolder)
+	}
+
+	if queue.nexusApi != nil {
+		api := *queue.nexusApi
+		api.Downloader.OnProgress = onProgress
+		file, err := api.Download(step, queue.downloadFolder)
+		if err == nil {
+			return file, nil
+		}
+		fmt.Println(fmt.Sprint("Nexus API download of ", step.SiteFileName, " failed, using the browser: ", err))
+	}
+
+	if *page == nil {
+		*page = queue.newPage()
+	}
+	if err := nextPage(*page, queue.profile.ModFilesUrl(step.ModId)); err != nil {
+		return DownloadedFile{}, err
+	}
+	return TryNexusDownload(*page, queue.profile, step, queue.downloadFolder, onProgress)
+}
+
+// tryDownload turns panics from the browser into errors
+func (queue *DownloadQueue) tryDownload(step DownloadStep, page **rod.Page) (file DownloadedFile, err error) {
+	defer func() {
+		if val := recover(); val != nil {
+			err = fmt.Errorf("browser error: %v", val)
+		}
+	}()
+	return queue.download(step, page)
+}
+
+// downloadWithRetries tries a step DOWNLOAD_ATTEMPTS times, waiting longer after every failure.
+// The page is dumped to the debug folder when the last attempt fails.
+func (queue *DownloadQueue) downloadWithRetries(step DownloadStep, page **rod.Page) (DownloadedFile, error) {
+	var err error
+	backoff := RETRY_BACKOFF
+	for attempt := 1; attempt <= DOWNLOAD_ATTEMPTS; attempt++ {
+		var file DownloadedFile
+		file, err = queue.tryDownload(step, page)
+		if err == nil {
+			return file, nil
+		}
+		if attempt < DOWNLOAD_ATTEMPTS {
+			fmt.Println(fmt.Sprint("Attempt ", attempt, " of ", DOWNLOAD_ATTEMPTS, " for ", step.SiteFileName, " failed, retrying in ", backoff, ": ", err))
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	if step.Type == NEXUS && *page != nil {
+		if saved := DumpPage(*page, fmt.Sprint(step.ModId)); len(saved) > 0 {
+			fmt.Println(fmt.Sprint("Saved the page of ", step.SiteFileName, " to ", strings.Join(saved, " and ")))
+		}
+	}
+	return DownloadedFile{}, err
+}
+
+// Run downloads every step, and returns the steps that failed
+func (queue *DownloadQueue) Run(steps []DownloadStep) []DownloadStep {
+	queue.progress = NewProgressTracker("Downloads", len(steps))
+	jobs := make(chan DownloadStep)
+	failedLock := sync.Mutex{}
+	failed := []DownloadStep{}
+
+	stopReport := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(PROGRESS_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopReport:
+				return
+			case <-ticker.C:
+				if report := queue.progress.Report(); len(report) > 1 {
+					fmt.Println(strings.Join(report, "\n"))
+				}
+			}
+		}
+	}()
+
+	workers := sync.WaitGroup{}
+	for i := 0; i < queue.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			var page *rod.Page
+			for step := range jobs {
+				queue.waitForHost(step)
+				queue.progress.Start(downloadStepKey(step), step.SiteFileName)
+				file, err := queue.downloadWithRetries(step, &page)
+				queue.progress.Finish(downloadStepKey(step))
+				if err != nil {
+					fmt.Println(fmt.Sprint("Skipping ", step.SiteFileName, ": ", err))
+					failedLock.Lock()
+					failed = append(failed, step)
+					failedLock.Unlock()
+					continue
+				}
+				fmt.Println(fmt.Sprint("Downloaded ", file.FileName))
+				queue.addRecord(step, file)
+			}
+			if page != nil {
+				page.Close()
+			}
+		}()
+	}
+
+	for _, step := range steps {
+		jobs <- step
+	}
+	close(jobs)
+	workers.Wait()
+	stopReport <- true
+	// the browser is only started when a worker needed it
+	if queue.browser != nil {
+		queue.browser.MustClose()
+		queue.browser = nil
+	}
+	return failed
+}
@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+func TestProgressTrackerKeysByStep(t *testing.T) {
+	first := DownloadStep{Type: NEXUS, ModId: 1, SiteFileName: "Main File"}
+	second := DownloadStep{Type: NEXUS, ModId: 2, SiteFileName: "Main File"}
+	tracker := NewProgressTracker("Downloads", 2)
+	tracker.Start(downloadStepKey(first), first.SiteFileName)
+	tracker.Start(downloadStepKey(second), second.SiteFileName)
+	tracker.Finish(downloadStepKey(first))
+	if report := tracker.Report(); len(report) != 2 {
+		t.Errorf("report = %v, want the second download still running", report)
+	}
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/nwaples/rardecode"
+	"github.com/ulikunitz/xz"
+)
+
+// Archives are extracted by the Extractor for their format, which is found from the first bytes
+// of the file rather than its name. Archives inside archives can be extracted too, when the
+// download step asks for it.
+
+// archive names Aradir knows, longest first so .tar.gz wins over .gz
+var SUPPORTED_ARCHIVE_FORMATS = []string{".tar.gz", ".tar.xz", ".tar.bz2", ".tgz", ".txz", ".tbz2", ".zip", ".7z", ".rar", ".tar"}
+
+const SNIFF_SIZE = 512
+const NESTED_ARCHIVE_DEPTH = 3
+
+type Extractor interface {
+	Name() string
+	Match(header []byte) bool // header is the first SNIFF_SIZE bytes of the file, or less
+	List(archivePath string) ([]string, error)
+	Size(archivePath string, include func(name string) bool) (int64, error) // uncompressed size of the included entries
+	Extract(archivePath string, dest string, options ExtractOptions) error
+}
+
+// ExtractOptions narrow down and follow an extraction, both are optional
+type ExtractOptions struct {
+	Include func(name string) bool // entries it returns false for are skipped
+	OnWrite func(written int64)    // called with the bytes of every write
+}
+
+func (options ExtractOptions) includes(name string) bool {
+	return includedEntry(options.Include, name)
+}
+
+func includedEntry(include func(name string) bool, name string) bool {
+	return include == nil || include(name)
+}
+
+var EXTRACTORS = []Extractor{ZipExtractor{}, SevenZipExtractor{}, RarExtractor{}, TarExtractor{}}
+
+var ZIP_MAGIC = [][]byte{[]byte("PK\x03\x04"), []byte("PK\x05\x06")}
+var SEVEN_ZIP_MAGIC = []byte("7z\xbc\xaf\x27\x1c")
+var RAR_MAGIC = []byte("Rar!\x1a\x07")
+var GZIP_MAGIC = []byte("\x1f\x8b")
+var XZ_MAGIC = []byte("\xfd7zXZ\x00")
+var BZIP2_MAGIC = []byte("BZh")
+var TAR_MAGIC = []byte("ustar")
+
+const TAR_MAGIC_OFFSET = 257
+
+// FindExtractor picks the extractor for an archive from its first bytes
+func FindExtractor(archivePath string) (Extractor, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header := make([]byte, SNIFF_SIZE)
+	count, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	header = header[:count]
+
+	for _, extractor := range EXTRACTORS {
+		if extractor.Match(header) {
+			return extractor, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not an archive Aradir can extract", filepath.Base(archivePath))
+}
+
+// ExtractArchive extracts an archive into dest, and with nested set the archives inside it too.
+// The options only apply to the outer archive.
+func ExtractArchive(archivePath string, dest string, nested bool, options ExtractOptions) error {
+	extractor, err := FindExtractor(archivePath)
+	if err != nil {
+		return err
+	}
+	if err := extractor.Extract(archivePath, dest, options); err != nil {
+		return fmt.Errorf("extracting %s as %s: %w", filepath.Base(archivePath), extractor.Name(), err)
+	}
+	if nested {
+		return extractNested(dest, NESTED_ARCHIVE_DEPTH)
+	}
+	return nil
+}
+
+// extractNested extracts every archive under dest into a folder beside it, named like the archive,
+// and removes the archive. Archives found inside those are extracted until depth runs out.
+func extractNested(dest string, depth int) error {
+	if depth == 0 {
+		return nil
+	}
+	archives := []string{}
+	err := filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if _, err := FindExtractor(path); err == nil {
+			archives = append(archives, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, archivePath := range archives {
+		folder := filepath.Join(filepath.Dir(archivePath), getFileName(filepath.Base(archivePath)))
+		if folder == archivePath {
+			folder = fmt.Sprint(archivePath, "_extracted")
+		}
+		if err := ExtractArchive(archivePath, folder, false, ExtractOptions{}); err != nil {
+			return err
+		}
+		if err := os.Remove(archivePath); err != nil {
+			return err
+		}
+		if err := extractNested(folder, depth-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArchiveSize adds up the uncompressed sizes of the entries of an archive, or only the included ones
+func ArchiveSize(archivePath string, include func(name string) bool) (int64, error) {
+	extractor, err := FindExtractor(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	return extractor.Size(archivePath, include)
+}
+
+// ListArchive lists the paths inside an archive, with forward slashes
+func ListArchive(archivePath string) ([]string, error) {
+	extractor, err := FindExtractor(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := extractor.List(archivePath)
+	for i, entry := range entries {
+		entries[i] = strings.ReplaceAll(entry, "\\", "/")
+	}
+	return entries, err
+}
+
+// extractPath joins an archive entry to dest, refusing entries that would end up outside of it
+func extractPath(dest string, name string) (string, error) {
+	path := filepath.Join(dest, strings.ReplaceAll(name, "\\", "/"))
+	if path != filepath.Clean(dest) && !strings.HasPrefix(path, filepath.Clean(dest)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%s points outside of the archive folder", name)
+	}
+	return path, nil
+}
+
+// countingReader reports the bytes read through it
+type countingReader struct {
+	reader  io.Reader
+	onWrite func(written int64)
+}
+
+func (counter countingReader) Read(data []byte) (int, error) {
+	count, err := counter.reader.Read(data)
+	if count > 0 {
+		counter.onWrite(int64(count))
+	}
+	return count, err
+}
+
+// writeEntry writes one archive entry below dest
+func writeEntry(dest string, name string, isDir bool, reader io.Reader, onWrite func(written int64)) error {
+	path, err := extractPath(dest, name)
+	if err != nil {
+		return err
+	}
+	if isDir {
+		return os.MkdirAll(path, os.ModeDir|os.ModePerm)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	// archives don't reliably store permissions
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if onWrite != nil {
+		reader = countingReader{reader: reader, onWrite: onWrite}
+	}
+	_, copyErr := io.Copy(file, reader)
+	closeErr := file.Close()
+	if copyErr != nil {
+		return copyErr
+	}
+	return closeErr
+}
+
+type ZipExtractor struct{}
+
+func (ZipExtractor) Name() string { return "zip" }
+
+func (ZipExtractor) Match(header []byte) bool {
+	for _, magic := range ZIP_MAGIC {
+		if bytes.HasPrefix(header, magic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ZipExtractor) List(archivePath string) ([]string, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	entries := []string{}
+	for _, file := range reader.File {
+		entries = append(entries, file.Name)
+	}
+	return entries, nil
+}
+
+func (ZipExtractor) Size(archivePath string, include func(name string) bool) (int64, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	size := int64(0)
+	for _, file := range reader.File {
+		if includedEntry(include, file.Name) {
+			size += int64(file.UncompressedSize64)
+		}
+	}
+	return size, nil
+}
+
+func (ZipExtractor) Extract(archivePath string, dest string, options ExtractOptions) error {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		if !options.includes(file.Name) {
+			continue
+		}
+		entry, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = writeEntry(dest, file.Name, file.FileInfo().IsDir(), entry, options.OnWrite)
+		entry.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type SevenZipExtractor struct{}
+
+func (SevenZipExtractor) Name() string { return "7z" }
+
+func (SevenZipExtractor) Match(header []byte) bool {
+	return bytes.HasPrefix(header, SEVEN_ZIP_MAGIC)
+}
+
+func (SevenZipExtractor) List(archivePath string) ([]string, error) {
+	reader, err := sevenzip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	entries := []string{}
+	for _, file := range reader.File {
+		entries = append(entries, file.Name)
+	}
+	return entries, nil
+}
+
+func (SevenZipExtractor) Size(archivePath string, include func(name string) bool) (int64, error) {
+	reader, err := sevenzip.OpenReader(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	size := int64(0)
+	for _, file := range reader.File {
+		if includedEntry(include, file.Name) {
+			size += int64(file.UncompressedSize)
+		}
+	}
+	return size, nil
+}
+
+// Extract opens the files in the order of their data. sevenzip keeps the decompressor of each solid
+// folder where the last file ended, so every folder is decompressed once, and skipped files are
+// decompressed past when the next file is opened.
+func (SevenZipExtractor) Extract(archivePath string, dest string, options ExtractOptions) error {
+	reader, err := sevenzip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		if !options.includes(file.Name) {
+			continue
+		}
+		if file.FileInfo().IsDir() {
+			if err := writeEntry(dest, file.Name, true, nil, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		entry, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = writeEntry(dest, file.Name, false, entry, options.OnWrite)
+		closeErr := entry.Close()
+		if err != nil {
+			return err
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+	return nil
+}
+
+type RarExtractor struct{}
+
+func (RarExtractor) Name() string { return "rar" }
+
+func (RarExtractor) Match(header []byte) bool {
+	return bytes.HasPrefix(header, RAR_MAGIC)
+}
+
+// walk calls onEntry for every entry of a rar archive, the reader is positioned on its data
+func (RarExtractor) walk(archivePath string, onEntry func(header *rardecode.FileHeader, reader io.Reader) error) error {
+	reader, err := rardecode.OpenReader(archivePath, "")
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := onEntry(header, reader); err != nil {
+			return err
+		}
+	}
+}
+
+func (extractor RarExtractor) List(archivePath string) ([]string, error) {
+	entries := []string{}
+	err := extractor.walk(archivePath, func(header *rardecode.FileHeader, reader io.Reader) error {
+		entries = append(entries, header.Name)
+		return nil
+	})
+	return entries, err
+}
+
+// Size reads the file headers only, walking solid archives with rardecode would decompress them
+func (RarExtractor) Size(archivePath string, include func(name string) bool) (int64, error) {
+	sizes, err := ReadRarHeaderSizes(archivePath, include)
+	if err == errRarEncryptedHeaders {
+		return estimatedSize(archivePath)
+	}
+	if len(sizes.Unknown) > 0 {
+		fmt.Println(fmt.Sprint("Warning: ", len(sizes.Unknown), " files of ", filepath.Base(archivePath), " don't record their size, and are not counted"))
+	}
+	return sizes.Size, err
+}
+
+func (extractor RarExtractor) Extract(archivePath string, dest string, options ExtractOptions) error {
+	return extractor.walk(archivePath, func(header *rardecode.FileHeader, reader io.Reader) error {
+		if !options.includes(header.Name) {
+			return nil
+		}
+		return writeEntry(dest, header.Name, header.IsDir, reader, options.OnWrite)
+	})
+}
+
+// TarExtractor handles plain tar, and tar compressed with gzip, xz or bzip2
+type TarExtractor struct{}
+
+func (TarExtractor) Name() string { return "tar" }
+
+func (TarExtractor) Match(header []byte) bool {
+	if len(header) >= TAR_MAGIC_OFFSET+len(TAR_MAGIC) && bytes.Equal(header[TAR_MAGIC_OFFSET:TAR_MAGIC_OFFSET+len(TAR_MAGIC)], TAR_MAGIC) {
+		return true
+	}
+	return bytes.HasPrefix(header, GZIP_MAGIC) || bytes.HasPrefix(header, XZ_MAGIC) || bytes.HasPrefix(header, BZIP2_MAGIC)
+}
+
+// walk calls onEntry for every entry of the tar, after undoing the compression
+func (TarExtractor) walk(archivePath string, onEntry func(header *tar.Header, reader io.Reader) error) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	header := make([]byte, len(XZ_MAGIC))
+	count, _ := io.ReadFull(file, header)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var stream io.Reader = file
+	switch header = header[:count]; {
+	case bytes.HasPrefix(header, GZIP_MAGIC):
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		stream = gzipReader
+	case bytes.HasPrefix(header, XZ_MAGIC):
+		if stream, err = xz.NewReader(file); err != nil {
+			return err
+		}
+	case bytes.HasPrefix(header, BZIP2_MAGIC):
+		stream = bzip2.NewReader(file)
+	}
+
+	reader := tar.NewReader(stream)
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("not a tar archive: %w", err)
+		}
+		if err := onEntry(entry, reader); err != nil {
+			return err
+		}
+	}
+}
+
+func (extractor TarExtractor) List(archivePath string) ([]string, error) {
+	entries := []string{}
+	err := extractor.walk(archivePath, func(header *tar.Header, reader io.Reader) error {
+		entries = append(entries, header.Name)
+		return nil
+	})
+	return entries, err
+}
+
+// Size walks the headers of plain tars. Compressed tars would have to be decompressed for that,
+// so the whole tar is counted, with the size gzip or xz recorded, or an estimate for bzip2.
+func (extractor TarExtractor) Size(archivePath string, include func(name string) bool) (int64, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	header := make([]byte, len(XZ_MAGIC))
+	count, _ := io.ReadFull(file, header)
+	header = header[:count]
+	recorded, ok := int64(0), false
+	compressed := true
+	switch {
+	case bytes.HasPrefix(header, GZIP_MAGIC):
+		recorded, ok = gzipRecordedSize(file, info.Size())
+	case bytes.HasPrefix(header, XZ_MAGIC):
+		recorded, ok = xzRecordedSize(file, info.Size())
+	case bytes.HasPrefix(header, BZIP2_MAGIC):
+	default:
+		compressed = false
+	}
+	file.Close()
+	if ok {
+		return recorded, nil
+	}
+	if compressed {
+		return estimatedSize(archivePath)
+	}
+
+	size := int64(0)
+	err = extractor.walk(archivePath, func(header *tar.Header, reader io.Reader) error {
+		if includedEntry(include, header.Name) {
+			size += header.Size
+		}
+		return nil
+	})
+	return size, err
+}
+
+func (extractor TarExtractor) Extract(archivePath string, dest string, options ExtractOptions) error {
+	return extractor.walk(archivePath, func(header *tar.Header, reader io.Reader) error {
+		if !options.includes(header.Name) {
+			return nil
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			return writeEntry(dest, header.Name, true, reader, nil)
+		case tar.TypeReg, tar.TypeRegA:
+			return writeEntry(dest, header.Name, false, reader, options.OnWrite)
+		}
+		// links and devices have no place in a mod
+		return nil
+	})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Archives are extracted by a pool of workers, parallelExtractions at a time. Before anything is
+// extracted, the uncompressed sizes of all archives are added up and compared with the free space
+// of the mod install folder, so a full disk stops Aradir before the first archive instead of halfway.
+// Each archive is extracted into a .partial folder that is renamed into place once it is complete,
+// and a marker beside the folder records the archive it came from.
+
+const DEFAULT_PARALLEL_EXTRACTIONS = 2
+const EXTRACT_MARKER_EXT = ".extracted"
+
+// ExtractMarker is written beside an extracted folder, once it is complete
+type ExtractMarker struct {
+	FileName string   `yaml:"fileName"`
+	Sha256   string   `yaml:"sha256"`
+	Size     int64    `yaml:"size"`
+	Paths    []string `yaml:"paths,omitempty"` // paths extracted by selectiveExtract, none for the whole archive
+}
+
+func getExtractMarkerPath(location string) string {
+	return fmt.Sprint(location, EXTRACT_MARKER_EXT)
+}
+
+func ReadExtractMarker(location string) (ExtractMarker, error) {
+	marker := ExtractMarker{}
+	file, err := ioutil.ReadFile(getExtractMarkerPath(location))
+	if err != nil {
+		return marker, err
+	}
+	err = yaml.Unmarshal(file, &marker)
+	return marker, err
+}
+
+func WriteExtractMarker(location string, record ManifestRecord, paths []string) error {
+	data, err := yaml.Marshal(ExtractMarker{FileName: record.FileName, Sha256: record.Sha256, Size: record.Size, Paths: paths})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(getExtractMarkerPath(location), data, 0644)
+}
+
+// CompleteExtraction returns the marker of location when it holds a complete extraction of the archive
+// of a record. Folders without a marker were cut off, or extracted from another archive, and have to be redone.
+func CompleteExtraction(location string, record ManifestRecord) (ExtractMarker, bool) {
+	if exists, _ := Exists(location); !exists {
+		return ExtractMarker{}, false
+	}
+	marker, err := ReadExtractMarker(location)
+	return marker, err == nil && record.Sha256 != "" && marker.Sha256 == record.Sha256
+}
+
+// extractJob extracts into a staging folder and moves it into place when everything is written
+func extractJob(job ExtractJob, onWrite func(written int64)) error {
+	staging := fmt.Sprint(job.Location, PARTIAL_EXT)
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	options := ExtractOptions{Include: archivePathFilter(job.Paths), OnWrite: onWrite}
+	if err := ExtractArchive(job.ArchivePath, staging, job.Nested, options); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+
+	if job.Extracted != nil {
+		// the marker is only extended once the new paths are in place
+		found, err := mergeExtractedPaths(staging, job.Location, job.Record.FileName, job.Paths)
+		if err != nil {
+			// the paths already in place are kept in the marker
+			if len(found) > 0 {
+				WriteExtractMarker(job.Location, job.Record, append(job.Extracted, found...))
+			}
+			return err
+		}
+		return WriteExtractMarker(job.Location, job.Record, append(job.Extracted, found...))
+	}
+	paths := job.Paths
+	if paths != nil {
+		found, err := extractedPaths(staging, job.Record.FileName, paths)
+		if err != nil {
+			os.RemoveAll(staging)
+			return err
+		}
+		paths = found
+	}
+
+	// the old folder and its marker go first, a folder without a marker is never taken as complete
+	os.Remove(getExtractMarkerPath(job.Location))
+	if err := os.RemoveAll(job.Location); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, job.Location); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+	return WriteExtractMarker(job.Location, job.Record, paths)
+}
+
+// ExtractJob is an archive that still has to be extracted
+type ExtractJob struct {
+	Record      ManifestRecord
+	ArchivePath string
+	Location    string
+	Nested      bool
+	Paths       []string // only these paths are extracted, nil for the whole archive
+	Extracted   []string // paths already in Location, when the job only adds the missing ones
+	Size        int64    // uncompressed size, nested archives not included
+}
+
+// existingParent walks up from a folder that may not exist yet to the first one that does
+func existingParent(folder string) string {
+	folder = filepath.Clean(folder)
+	for {
+		if exists, _ := Exists(folder); exists {
+			return folder
+		}
+		parent := filepath.Dir(folder)
+		if parent == folder {
+			return folder
+		}
+		folder = parent
+	}
+}
+
+// MeasureExtractJobs reads the uncompressed size of every job from the archive
+func MeasureExtractJobs(jobs []ExtractJob) error {
+	for i, job := range jobs {
+		size, err := ArchiveSize(job.ArchivePath, archivePathFilter(job.Paths))
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", job.Record.FileName, err)
+		}
+		jobs[i].Size = size
+	}
+	return nil
+}
+
+// CheckDiskSpace compares the size of all jobs with the free space of the install folder.
+// When they don't fit, the error lists how much every mod needs.
+func CheckDiskSpace(jobs []ExtractJob, installFolder string) error {
+	needed := int64(0)
+	for _, job := range jobs {
+		needed += job.Size
+	}
+	free, err := FreeDiskSpace(existingParent(installFolder))
+	if err != nil {
+		return fmt.Errorf("checking free space of %s: %w", installFolder, err)
+	}
+	if needed <= free {
+		return nil
+	}
+
+	lines := []string{fmt.Sprintf("Extracting needs %s, but %s only has %s free:", formatBytes(needed), installFolder, formatBytes(free))}
+	for _, job := range jobs {
+		lines = append(lines, fmt.Sprintf("  %s (%d): %s", job.Record.FileDisplayName, job.Record.ModId, formatBytes(job.Size)))
+	}
+	return fmt.Errorf("%s", strings.Join(lines, "\n"))
+}
+
+// RunExtraction extracts the jobs across workers and returns the errors of the ones that failed
+func RunExtraction(jobs []ExtractJob, workers int) []error {
+	if workers <= 0 {
+		workers = DEFAULT_PARALLEL_EXTRACTIONS
+	}
+	progress := NewProgressTracker("Extracting", len(jobs))
+	queue := make(chan ExtractJob)
+	errsLock := sync.Mutex{}
+	errs := []error{}
+
+	stopReport := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(PROGRESS_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopReport:
+				return
+			case <-ticker.C:
+				if report := progress.Report(); len(report) > 1 {
+					fmt.Println(strings.Join(report, "\n"))
+				}
+			}
+		}
+	}()
+
+	pool := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		pool.Add(1)
+		go func() {
+			defer pool.Done()
+			for job := range queue {
+				name := job.Record.FileName
+				written := int64(0)
+				progress.Start(name, name)
+				err := extractJob(job, func(count int64) {
+					written += count
+					progress.Update(name, written, job.Size)
+				})
+				progress.Finish(name)
+				if err != nil {
+					errsLock.Lock()
+					errs = append(errs, err)
+					errsLock.Unlock()
+					continue
+				}
+				fmt.Println(fmt.Sprint("Extracted ", name))
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	pool.Wait()
+	stopReport <- true
+	return errs
+}
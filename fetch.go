@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"strings"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-rod/rod"
@@ -12,72 +17,143 @@ import (
 
 const NEXUS_MODS_URL = "https://www.nexusmods.com/morrowind/mods/"
 
-func createRodHandler() *rod.Browser {
+// how long each stage of the files page may take before it counts as failed
+const NEXUS_STAGE_TIMEOUT = 30 * time.Second
+const NEXUS_POPUP_TIMEOUT = 3 * time.Second
+const NEXUS_DOWNLOAD_START_TIMEOUT = 60 * time.Second
+const NEXUS_DOWNLOAD_STALL_TIMEOUT = 2 * time.Minute
+
+// screenshots and HTML of pages that failed to download
+const DEBUG_FOLDER = "./debug"
+
+func createRodHandler(downloadFolder string) *rod.Browser {
 	u := launcher.NewUserMode().MustLaunch()
 
-	return rod.New().ControlURL(u).MustConnect().NoDefaultDevice()
+	browser := rod.New().ControlURL(u).MustConnect().NoDefaultDevice()
+	setDownloadFolder(browser, downloadFolder)
+	return browser
 }
 
-func createPageHandler() *rod.Page {
-	rod := createRodHandler()
-	return rod.MustPage(NEXUS_MODS_URL)
+// setDownloadFolder saves browser downloads into the download folder. Files are named by their
+// download GUID until they finish, then renamed to the name the site suggested.
+func setDownloadFolder(browser *rod.Browser, downloadFolder string) {
+	downloadPath, err := filepath.Abs(downloadFolder)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = proto.BrowserSetDownloadBehavior{
+		Behavior:      proto.BrowserSetDownloadBehaviorBehaviorAllowAndName,
+		DownloadPath:  downloadPath,
+		EventsEnabled: true,
+	}.Call(browser)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
-func createJSSelector(elementType string, replaceString string) string {
-	selector := `() => {
-		const text = "TEXTCONTENT";
-		const elements = Array.from(document.querySelectorAll("ELEMENTTYPE"));
-	
-	return elements.find(el => {
-		return el.textContent.includes(text);
-	});
-	}`
-
-	selector = strings.Replace(selector, "TEXTCONTENT", replaceString, 1)
-	selector = strings.Replace(selector, "ELEMENTTYPE", elementType, 1)
-
-	return selector
+// watchDownload follows the next download started by a page until it completes or is canceled.
+// The returned wait gives up when no download starts within NEXUS_DOWNLOAD_START_TIMEOUT, or a
+// started one makes no progress for NEXUS_DOWNLOAD_STALL_TIMEOUT. stop ends the watch without waiting.
+func watchDownload(page *rod.Page, onProgress func(received int64, total int64)) (wait func() (proto.BrowserDownloadWillBegin, proto.BrowserDownloadProgressState, error), stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	download := proto.BrowserDownloadWillBegin{}
+	state := proto.BrowserDownloadProgressStateInProgress
+	started := int32(0)
+	// set by wait, the handlers only run while it waits
+	var timer *time.Timer
+	waitEvents := page.Browser().Context(ctx).EachEvent(func(e *proto.BrowserDownloadWillBegin) {
+		// other tabs download at the same time
+		if download.GUID == "" && e.FrameID == page.FrameID {
+			download = *e
+			atomic.StoreInt32(&started, 1)
+			timer.Reset(NEXUS_DOWNLOAD_STALL_TIMEOUT)
+		}
+	}, func(e *proto.BrowserDownloadProgress) bool {
+		if e.GUID != download.GUID {
+			return false
+		}
+		state = e.State
+		timer.Reset(NEXUS_DOWNLOAD_STALL_TIMEOUT)
+		onProgress(int64(e.ReceivedBytes), int64(e.TotalBytes))
+		return state == proto.BrowserDownloadProgressStateCompleted || state == proto.BrowserDownloadProgressStateCanceled
+	})
+
+	wait = func() (proto.BrowserDownloadWillBegin, proto.BrowserDownloadProgressState, error) {
+		timer = time.AfterFunc(NEXUS_DOWNLOAD_START_TIMEOUT, cancel)
+		waitEvents()
+		timer.Stop()
+		cancel()
+		if atomic.LoadInt32(&started) == 0 {
+			return download, state, fmt.Errorf("no download started within %s", NEXUS_DOWNLOAD_START_TIMEOUT)
+		}
+		if state == proto.BrowserDownloadProgressStateInProgress {
+			// the browser would keep the download going in the background otherwise
+			proto.BrowserCancelDownload{GUID: download.GUID}.Call(page.Browser())
+			return download, state, fmt.Errorf("download of %s made no progress for %s", download.SuggestedFilename, NEXUS_DOWNLOAD_STALL_TIMEOUT)
+		}
+		return download, state, nil
+	}
+	return wait, cancel
 }
 
-func TryNexusDownload(page *rod.Page, siteFileName string) string {
-	tabs := page.MustElement(".modtabs")
-	tabs.MustElementR("span", `FILES`).MustClick()
+// TryNexusDownload downloads a file by running the site profile on the mod's files page, and returns
+// the file name once it has finished. Every action of the profile has NEXUS_STAGE_TIMEOUT to finish.
+func TryNexusDownload(page *rod.Page, profile SiteProfile, step DownloadStep, downloadFolder string, onProgress func(received int64, total int64)) (DownloadedFile, error) {
+	// watch before clicking, so a small file can't finish before we listen
+	waitDownload, stopWatching := watchDownload(page, onProgress)
 
-	selector := createJSSelector("dt", siteFileName)
-	section := page.MustElement("#mod_files").MustElementByJS(selector).MustNext()
-	link := section.MustElementR("span", "/Manual download/i").MustParent()
-	link.MustClick()
-
-	time.Sleep(1 * time.Second)
-	if page.MustHas(".popup-mod-requirements") {
-		popup, _ := page.Element(".popup-mod-requirements")
-		popup.MustElementR("a.btn", "/download/i").MustClick()
+	captured, err := RunSiteProfile(page, profile, step)
+	if err != nil {
+		stopWatching()
+		return DownloadedFile{}, err
 	}
 
-	time.Sleep(1 * time.Second)
-	fileName := ""
-	if page.MustHas("div.page-layout") && page.MustHas("div.donation-wrapper") {
-		tabSection, _ := page.Element("div.tabcontent")
-		fileNameContainer, _ := tabSection.Element("div.header")
-		readName, _ := fileNameContainer.Text()
+	download, state, err := waitDownload()
+	if err != nil {
+		return DownloadedFile{}, err
+	}
+	if state != proto.BrowserDownloadProgressStateCompleted {
+		return DownloadedFile{}, fmt.Errorf("download of %s was %s", download.SuggestedFilename, state)
+	}
 
-		// Might be fragile, since it catches other content
-		splitName := strings.Split(readName, "\n")
-		fileName = splitName[0]
+	file := DownloadedFile{FileName: download.SuggestedFilename, Version: captured["version"]}
+	file.FileId, _ = strconv.ParseInt(captured["fileId"], 10, 64)
+	filePath := fmt.Sprint(downloadFolder, "/", file.FileName)
+	os.Remove(filePath)
+	if err := os.Rename(fmt.Sprint(downloadFolder, "/", download.GUID), filePath); err != nil {
+		return DownloadedFile{}, err
 	}
+	return file, nil
+}
 
-	wait := page.WaitEvent(proto.PageDownloadWillBegin{})
-	// proto.BrowserDownloadProgress{}
-	// watch for proto.BrowserDownloadProgress by event.GUID and event.State
+func nextPage(page *rod.Page, url string) error {
+	timed := page.Timeout(NEXUS_STAGE_TIMEOUT)
+	wait := timed.WaitNavigation(proto.PageLifecycleEventNameNetworkAlmostIdle)
+	if err := timed.Navigate(url); err != nil {
+		return fmt.Errorf("opening %s: %w", url, err)
+	}
 	wait()
-
-	return fileName
+	return nil
 }
 
-func nextPage(page *rod.Page, url string) {
-	wait := page.MustWaitNavigation()
-	page.MustNavigate(url)
-	wait()
+// DumpPage saves a screenshot and the HTML of a page to the debug folder, and returns the file names
+func DumpPage(page *rod.Page, name string) []string {
+	checkError(os.MkdirAll(DEBUG_FOLDER, os.ModeDir|os.ModePerm))
+	base := fmt.Sprint(DEBUG_FOLDER, "/", name, "-", time.Now().Format("20060102-150405"))
+	timed := page.Timeout(NEXUS_STAGE_TIMEOUT)
+	saved := []string{}
+
+	if screenshot, err := timed.Screenshot(false, nil); err == nil {
+		if err := os.WriteFile(fmt.Sprint(base, ".png"), screenshot, 0644); err == nil {
+			saved = append(saved, fmt.Sprint(base, ".png"))
+		}
+	}
+	if html, err := timed.HTML(); err == nil {
+		if err := os.WriteFile(fmt.Sprint(base, ".html"), []byte(html), 0644); err == nil {
+			saved = append(saved, fmt.Sprint(base, ".html"))
+		}
+	}
+	return saved
 }
 
 func sliceContains(slice []string, val string) bool {
@@ -109,40 +185,43 @@ func getDownloadCount(steps []DownloadStep, modId int32) int {
 	return len(matchedRecords)
 }
 
-func DownloadMods(listName string, downloadFolder string) (ModListConfig, ManifestListConfig) {
-	fullFileName := fmt.Sprint(listName, ".yaml")
-	preset := ReadPreset(fullFileName)
-	var page = &rod.Page{}
-
+// DownloadMods fetches every download step that isn't downloaded yet. Nexus steps use the API when
+// an API key is set, and the browser otherwise or when the API can't hand out a link.
+// The steps that still failed after their retries are returned with the manifest.
+func DownloadMods(listName string, preset ModListConfig, prefs PreferencesConfig) (ManifestListConfig, []DownloadStep) {
 	// Check for existing manifest
-	// if manifest exists, return a list of siteFileNames that match the config
+	// if manifest exists, return the keys of the steps that are downloaded
 	// enables download skipping to save time and storage
-	downloadedMods, manifest := GetDownloadedMods(listName, downloadFolder)
-	if len(downloadedMods) == len(preset.DownloadSteps) {
-		return preset, manifest
-	}
-	page = createPageHandler()
+	downloadedMods, manifest := GetDownloadedMods(listName, prefs.Downloads, preset.DownloadSteps)
+	missingSteps := []DownloadStep{}
 	for _, step := range preset.DownloadSteps {
-		if sliceContains(downloadedMods, step.SiteFileName) {
-			continue
+		if !sliceContains(downloadedMods, downloadStepKey(step)) {
+			missingSteps = append(missingSteps, step)
 		}
-		fileName := ""
+	}
+	WriteManifest(&manifest, listName)
+	if len(missingSteps) == 0 {
+		return manifest, nil
+	}
 
-		nextPage(page, fmt.Sprint(NEXUS_MODS_URL, step.ModId, "/files"))
-		if step.Type == "nexus" {
-			fileName = TryNexusDownload(page, step.SiteFileName)
+	queue := NewDownloadQueue(listName, &manifest, prefs)
+	for _, step := range missingSteps {
+		if step.Type == NEXUS {
+			profile, err := ReadSiteProfile(NEXUS)
+			if err != nil {
+				log.Fatal(err)
+			}
+			queue.profile = profile
+			break
 		}
-
-		fmt.Println(fileName)
-		manifest.Records = append(manifest.Records, ManifestRecord{
-			FileName:        fileName,
-			ModId:           step.ModId,
-			FileDisplayName: step.SiteFileName,
-		})
-
-		time.Sleep(500 * time.Millisecond) // wait half a second before transitioning
 	}
 
-	WriteManifest(&manifest, listName)
-	return preset, manifest
+	failed := queue.Run(missingSteps)
+	if len(failed) > 0 {
+		fmt.Println(fmt.Sprint(len(failed), " of ", len(missingSteps), " downloads failed, run Aradir again to retry them:"))
+		for _, step := range failed {
+			fmt.Println(fmt.Sprint("  ", step.SiteFileName, " (mod ", step.ModId, ")"))
+		}
+	}
+	return manifest, failed
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// The base openmw.cfg is generated from the Morrowind installation, the same way
+// openmw-iniimporter does it, so leftovers in the user's own openmw.cfg never end up in a preset.
+
+const DEFAULT_ENCODING = "win1252"
+
+const MORROWIND_INI = "Morrowind.ini"
+const DATA_FILES = "Data Files"
+
+// archives of the base game, in the order of the plugins in CONTENT_FILTERS that they belong to
+var BASE_GAME_ARCHIVES = []string{"Morrowind.bsa", "Tribunal.bsa", "Bloodmoon.bsa"}
+
+var WEATHER_TYPES = []string{"Clear", "Cloudy", "Foggy", "Overcast", "Rain", "Thunderstorm", "Ashstorm", "Blight", "Snow", "Blizzard"}
+
+// FALLBACK_INI_KEYS are the Morrowind.ini keys OpenMW reads as fallback values, by section,
+// following the key list of openmw-iniimporter. Other keys in the same sections are not imported.
+var FALLBACK_INI_KEYS = map[string][]string{
+	"Fonts":   {"Font 0", "Font 1", "Font 2"},
+	"General": {"Werewolf FOV"},
+	"Inventory": {"DirectionalDiffuseR", "DirectionalDiffuseG", "DirectionalDiffuseB",
+		"DirectionalAmbientR", "DirectionalAmbientG", "DirectionalAmbientB",
+		"DirectionalRotationX", "DirectionalRotationY", "UniformScaling"},
+	"Map": {"Travel Siltstrider Red", "Travel Siltstrider Green", "Travel Siltstrider Blue",
+		"Travel Boat Red", "Travel Boat Green", "Travel Boat Blue",
+		"Travel Magic Red", "Travel Magic Green", "Travel Magic Blue", "Show Travel Lines"},
+	"Movies": {"Company Logo", "Morrowind Logo", "New Game", "Loading", "Options Menu"},
+	"LightAttenuation": {"UseConstant", "ConstantValue", "UseLinear", "LinearMethod", "LinearValue",
+		"LinearRadiusMult", "UseQuadratic", "QuadraticMethod", "QuadraticValue", "QuadraticRadiusMult", "OutQuadInLin"},
+	"Water": {"Map Alpha", "World Alpha", "SurfaceTextureSize", "SurfaceTileCount", "SurfaceFPS", "SurfaceTexture",
+		"SurfaceFrameCount", "TileTextureDivisor", "RippleTexture", "RippleFrameCount", "RippleLifetime",
+		"MaxNumberRipples", "RippleScale", "RippleRotSpeed", "RippleAlphas", "PSWaterReflectTerrain",
+		"PSWaterReflectUpdate", "NearWaterRadius", "NearWaterPoints", "NearWaterUnderwaterFreq",
+		"NearWaterUnderwaterVolume", "NearWaterIndoorTolerance", "NearWaterOutdoorTolerance",
+		"NearWaterIndoorID", "NearWaterOutdoorID", "UnderwaterSunriseFog", "UnderwaterDayFog",
+		"UnderwaterSunsetFog", "UnderwaterNightFog", "UnderwaterIndoorFog", "Underwater Color",
+		"Underwater Color Weight"},
+	"Weather": {"EnvReduceColor", "LerpCloseColor", "BumpFadeColor", "AlphaReduce",
+		"Minimum Time Between Environmental Sounds", "Maximum Time Between Environmental Sounds",
+		"Sun Glare Fader Max", "Sun Glare Fader Angle Max", "Sun Glare Fader Color", "Timescale Clouds",
+		"Precip Gravity", "Rain Ripples", "Rain Ripple Radius", "Rain Ripples Per Drop", "Rain Ripple Scale",
+		"Rain Ripple Speed", "Fog Depth Change Speed", "Sunrise Time", "Sunset Time", "Sunrise Duration",
+		"Sunset Duration", "Hours Between Weather Changes", "Stars Post-Sunset Start", "Stars Pre-Sunrise Finish",
+		"Stars Fading Duration", "Snow Ripples", "Snow Ripple Radius", "Snow Ripples Per Flake",
+		"Snow Ripple Scale", "Snow Ripple Speed", "Snow Gravity Scale", "Snow High Kill", "Snow Low Kill"},
+	"Moons": {"Masser Size", "Masser Fade In Start", "Masser Fade In Finish", "Masser Fade Out Start",
+		"Masser Fade Out Finish", "Masser Axis Offset", "Masser Speed", "Masser Daily Increment",
+		"Masser Fade Start Angle", "Masser Fade End Angle", "Masser Moon Shadow Early Fade Angle",
+		"Secunda Size", "Secunda Fade In Start", "Secunda Fade In Finish", "Secunda Fade Out Start",
+		"Secunda Fade Out Finish", "Secunda Axis Offset", "Secunda Speed", "Secunda Daily Increment",
+		"Secunda Fade Start Angle", "Secunda Fade End Angle", "Secunda Moon Shadow Early Fade Angle",
+		"Script Color"},
+	"Blood": {"Model 0", "Model 1", "Model 2",
+		"Texture 0", "Texture 1", "Texture 2", "Texture 3", "Texture 4", "Texture 5", "Texture 6", "Texture 7",
+		"Texture Name 0", "Texture Name 1", "Texture Name 2", "Texture Name 3", "Texture Name 4",
+		"Texture Name 5", "Texture Name 6", "Texture Name 7"},
+	"Level Up": {"Level2", "Level3", "Level4", "Level5", "Level6", "Level7", "Level8", "Level9", "Level10",
+		"Level11", "Level12", "Level13", "Level14", "Level15", "Level16", "Level17", "Level18", "Level19",
+		"Level20", "Default"},
+}
+
+// keys of every [Weather <type>] section
+var WEATHER_TYPE_KEYS = []string{"Cloud Texture", "Clouds Maximum Percent", "Transition Delta",
+	"Sky Sunrise Color", "Sky Day Color", "Sky Sunset Color", "Sky Night Color",
+	"Fog Sunrise Color", "Fog Day Color", "Fog Sunset Color", "Fog Night Color",
+	"Ambient Sunrise Color", "Ambient Day Color", "Ambient Sunset Color", "Ambient Night Color",
+	"Sun Sunrise Color", "Sun Day Color", "Sun Sunset Color", "Sun Night Color", "Sun Disc Sunset Color",
+	"Land Fog Day Depth", "Land Fog Night Depth", "Wind Speed", "Cloud Speed", "Glare View",
+	"Ambient Loop Sound ID", "Using Precip", "Rain Diameter", "Rain Height Min", "Rain Height Max",
+	"Rain Threshold", "Rain Entrance Speed", "Rain Loop Sound ID", "Max Raindrops",
+	"Thunder Sound ID 0", "Thunder Sound ID 1", "Thunder Sound ID 2", "Thunder Sound ID 3",
+	"Thunder Threshold", "Thunder Frequency", "Flash Decrement", "Storm Threshold", "Disease Chance",
+	"Snow Diameter", "Snow Height Min", "Snow Height Max", "Snow Entrance Speed", "Max Snowflakes",
+	"Snow Threshold"}
+
+// keys of the [Question 1] to [Question 10] sections of character creation
+var QUESTION_KEYS = []string{"Question", "AnswerOne", "AnswerTwo", "AnswerThree", "Sound"}
+
+var INI_ENCODINGS = map[string]encoding.Encoding{
+	"win1250": charmap.Windows1250,
+	"win1251": charmap.Windows1251,
+	"win1252": charmap.Windows1252,
+}
+
+type IniValue struct {
+	Section string
+	Key     string
+	Value   string
+}
+
+// ParseMorrowindIni reads every key of Morrowind.ini in order, decoding the text from the game's encoding
+func ParseMorrowindIni(reader io.Reader, encodingName string) ([]IniValue, error) {
+	textEncoding, ok := INI_ENCODINGS[encodingName]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q", encodingName)
+	}
+
+	values := []IniValue{}
+	section := ""
+	scanner := bufio.NewScanner(textEncoding.NewDecoder().Reader(reader))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if header, ok := parseSectionHeader(line); ok {
+			section = header
+		} else if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
+			values = append(values, IniValue{Section: section, Key: strings.TrimSpace(parts[0]), Value: strings.TrimSpace(parts[1])})
+		}
+	}
+	return values, scanner.Err()
+}
+
+// isFallbackKey reports whether OpenMW reads a Morrowind.ini key as a fallback value
+func isFallbackKey(value IniValue) bool {
+	keys := FALLBACK_INI_KEYS[value.Section]
+	if weather := strings.TrimPrefix(value.Section, "Weather "); weather != value.Section && sliceContains(WEATHER_TYPES, weather) {
+		keys = WEATHER_TYPE_KEYS
+	}
+	if question := strings.TrimPrefix(value.Section, "Question "); question != value.Section {
+		if number, err := strconv.Atoi(question); err == nil && number >= 1 && number <= 10 {
+			keys = QUESTION_KEYS
+		}
+	}
+	return sliceContains(keys, value.Key)
+}
+
+// FallbackValue turns a Morrowind.ini key into an openmw.cfg fallback value, ie
+// `[Weather Clear] Sky Sunrise Color=255,115,079` is `Weather_Clear_Sky_Sunrise_Color,255,115,079`
+func FallbackValue(value IniValue) string {
+	name := strings.ReplaceAll(fmt.Sprint(value.Section, " ", value.Key), " ", "_")
+	return fmt.Sprint(name, ",", value.Value)
+}
+
+// GenerateBaseConfig builds openmw.cfg for a clean Morrowind installation: the encoding,
+// fallback values, base archives, the Data Files folder and the base game plugins
+func GenerateBaseConfig(gamedata string, encodingName string) (*OpenMWConfig, error) {
+	config := &OpenMWConfig{Entries: []OpenMWConfigEntry{}}
+	dataFiles := fmt.Sprint(gamedata, "/", DATA_FILES)
+	if exists, _ := Exists(dataFiles); !exists {
+		return config, fmt.Errorf("%s was not found, check gamedata in preferences.yaml", dataFiles)
+	}
+
+	config.Add(CFG_ENCODING, encodingName)
+	config.Add(CFG_DATA, dataFiles)
+
+	iniPath := fmt.Sprint(gamedata, "/", MORROWIND_INI)
+	file, err := os.Open(iniPath)
+	if err != nil {
+		return config, fmt.Errorf("reading %s: %v", iniPath, err)
+	}
+	defer file.Close()
+	iniValues, err := ParseMorrowindIni(file, encodingName)
+	if err != nil {
+		return config, fmt.Errorf("reading %s: %v", iniPath, err)
+	}
+
+	for _, value := range iniValues {
+		if isFallbackKey(value) {
+			config.Add(CFG_FALLBACK, FallbackValue(value))
+		}
+	}
+
+	// [Game Files] and [Archives] of Morrowind.ini also list what was installed by hand, so only the
+	// base game and expansions found in Data Files are added. Morrowind.ini only lists the
+	// expansions once they were enabled in the original launcher anyway.
+	for i, plugin := range CONTENT_FILTERS {
+		if exists, _ := Exists(fmt.Sprint(dataFiles, "/", plugin)); !exists {
+			continue
+		}
+		config.Add(CFG_CONTENT, plugin)
+		if exists, _ := Exists(fmt.Sprint(dataFiles, "/", BASE_GAME_ARCHIVES[i])); exists {
+			config.Add(CFG_FALLBACK_ARCHIVE, BASE_GAME_ARCHIVES[i])
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateBaseConfigSkipsHandInstalledFiles(t *testing.T) {
+	gamedata := t.TempDir()
+	dataFiles := filepath.Join(gamedata, DATA_FILES)
+	os.MkdirAll(dataFiles, os.ModePerm)
+	for _, name := range []string{"Morrowind.esm", "Morrowind.bsa", "Tribunal.esm", "Tribunal.bsa", "Hand Installed.esp", "Hand Installed.bsa"} {
+		os.WriteFile(filepath.Join(dataFiles, name), []byte(name), 0644)
+	}
+	ini := "[Game Files]\nGameFile0=Morrowind.esm\nGameFile1=Hand Installed.esp\nGameFile2=Bloodmoon.esm\n" +
+		"[Archives]\nArchive 0=Hand Installed.bsa\nArchive 1=Tribunal.bsa\n" +
+		"[General]\nWerewolf FOV=100\nMaximum Shadows=4\n" +
+		"[Weather Clear]\nSky Sunrise Color=117,141,164\n" +
+		"[Weather Custom]\nSky Sunrise Color=1,2,3\n" +
+		"[Question 1]\nQuestion=Who are you?\n"
+	os.WriteFile(filepath.Join(gamedata, MORROWIND_INI), []byte(ini), 0644)
+
+	config, err := GenerateBaseConfig(gamedata, DEFAULT_ENCODING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := config.Values(CFG_CONTENT); !reflect.DeepEqual(content, []string{"Morrowind.esm", "Tribunal.esm"}) {
+		t.Errorf("content = %v, want the base game found in Data Files", content)
+	}
+	if archives := config.Values(CFG_FALLBACK_ARCHIVE); !reflect.DeepEqual(archives, []string{"Morrowind.bsa", "Tribunal.bsa"}) {
+		t.Errorf("archives = %v, want the base game archives", archives)
+	}
+	want := []string{"General_Werewolf_FOV,100", "Weather_Clear_Sky_Sunrise_Color,117,141,164", "Question_1_Question,Who are you?"}
+	if fallback := config.Values(CFG_FALLBACK); !reflect.DeepEqual(fallback, want) {
+		t.Errorf("fallback = %v, want %v", fallback, want)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Presets can extend another preset with `extends: <name>`. The base preset is
+// resolved first, then the derived steps are merged into it:
+//
+//   - a step whose key matches a base step replaces it in place
+//   - a step with `remove: true` deletes the base step with the same key
+//   - a step with `insertBefore: <key>` is inserted in front of that base step
+//   - any other step is appended
+//
+// Download steps are keyed by `id`, or by modId/siteFileName when no id is set.
+// Unpack steps can only be targeted when they have an `id`.
+// Base unpack steps keep pointing at the same download after the merge, and are
+// dropped when their download is removed. Derived fileIndex values count the
+// merged download list.
+
+type PresetMergeError struct {
+	Section string // downloadSteps or unpackSteps
+	Index   int    // index of the step in the derived preset
+	Message string
+}
+
+func (mergeErr PresetMergeError) Error() string {
+	return fmt.Sprintf("%s[%d]: %s", mergeErr.Section, mergeErr.Index, mergeErr.Message)
+}
+
+// ResolvePreset reads a preset and merges every preset it extends
+func ResolvePreset(fileName string) (ModListConfig, error) {
+	return resolvePreset(fileName, []string{})
+}
+
+func resolvePreset(fileName string, chain []string) (ModListConfig, error) {
+	presetName := strings.Replace(fileName, ".yaml", "", 1)
+	if sliceContains(chain, presetName) {
+		return ModListConfig{}, fmt.Errorf("preset %s extends itself: %s", presetName, strings.Join(append(chain, presetName), " -> "))
+	}
+
+	preset, err := readPresetFile(presetName)
+	if err != nil {
+		return preset, fmt.Errorf("preset %s: %v", presetName, err)
+	}
+	if preset.Extends == "" {
+		return preset, nil
+	}
+
+	base, err := resolvePreset(preset.Extends, append(chain, presetName))
+	if err != nil {
+		return preset, err
+	}
+
+	merged, mergeErrs := MergePresets(base, preset)
+	if len(mergeErrs) > 0 {
+		return preset, fmt.Errorf("preset %s: %v", presetName, mergeErrs[0])
+	}
+	return merged, nil
+}
+
+func downloadStepKey(step DownloadStep) string {
+	if step.Id != "" {
+		return step.Id
+	}
+	return fmt.Sprint(step.ModId, "/", step.SiteFileName)
+}
+
+func unpackStepKey(step UnpackStep) string {
+	return step.Id
+}
+
+// mergedStep remembers which base step an entry came from, -1 for derived steps
+type mergedStep[T DownloadStep | UnpackStep] struct {
+	step     T
+	baseFrom int
+}
+
+func findStepKey[T DownloadStep | UnpackStep](steps []mergedStep[T], key string, keyOf func(T) string) int {
+	if key == "" {
+		return -1
+	}
+	for i, entry := range steps {
+		if keyOf(entry.step) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func mergeSteps[T DownloadStep | UnpackStep](section string, base []T, derived []T, keyOf func(T) string, remove func(T) bool, insertBefore func(T) string) ([]mergedStep[T], []PresetMergeError) {
+	errs := []PresetMergeError{}
+	steps := []mergedStep[T]{}
+	for i, step := range base {
+		steps = append(steps, mergedStep[T]{step: step, baseFrom: i})
+	}
+
+	for i, step := range derived {
+		key := keyOf(step)
+		pos := findStepKey(steps, key, keyOf)
+		if remove(step) {
+			if pos < 0 {
+				errs = append(errs, PresetMergeError{Section: section, Index: i, Message: fmt.Sprintf("cannot remove %q, the base preset has no such step", key)})
+				continue
+			}
+			steps = append(steps[:pos], steps[pos+1:]...)
+		} else if pos >= 0 {
+			steps[pos].step = step
+		} else if before := insertBefore(step); before != "" {
+			target := findStepKey(steps, before, keyOf)
+			if target < 0 {
+				errs = append(errs, PresetMergeError{Section: section, Index: i, Message: fmt.Sprintf("cannot insert before %q, the base preset has no such step", before)})
+				continue
+			}
+			steps = append(steps[:target], append([]mergedStep[T]{{step: step, baseFrom: -1}}, steps[target:]...)...)
+		} else {
+			steps = append(steps, mergedStep[T]{step: step, baseFrom: -1})
+		}
+	}
+	return steps, errs
+}
+
+// remapUnpackSteps points base unpack steps at their downloads in the merged list
+func remapUnpackSteps(baseDownloads []DownloadStep, merged []mergedStep[DownloadStep], steps []UnpackStep) []UnpackStep {
+	remapped := []UnpackStep{}
+	for _, step := range steps {
+		if step.ModId <= 0 {
+			remapped = append(remapped, step)
+			continue
+		}
+
+		baseIndex := -1
+		seen := int16(0)
+		for i, download := range baseDownloads {
+			if download.ModId == step.ModId {
+				if seen == step.FileIndex {
+					baseIndex = i
+					break
+				}
+				seen++
+			}
+		}
+		if baseIndex < 0 {
+			// left as is so validation reports the bad fileIndex
+			remapped = append(remapped, step)
+			continue
+		}
+
+		fileIndex := int16(0)
+		found := false
+		for _, entry := range merged {
+			if entry.baseFrom == baseIndex {
+				found = true
+				break
+			}
+			if entry.step.ModId == step.ModId {
+				fileIndex++
+			}
+		}
+		if found {
+			step.FileIndex = fileIndex
+			remapped = append(remapped, step)
+		}
+	}
+	return remapped
+}
+
+// MergePresets applies the steps of a derived preset on top of its resolved base
+func MergePresets(base ModListConfig, derived ModListConfig) (ModListConfig, []PresetMergeError) {
+	merged := derived
+	if merged.ListUrl == "" {
+		merged.ListUrl = base.ListUrl
+	}
+
+	// derived options override base options with the same name
+	merged.Options = []PresetOption{}
+	for _, option := range base.Options {
+		if _, ok := findOption(derived.Options, option.Name); !ok {
+			merged.Options = append(merged.Options, option)
+		}
+	}
+	merged.Options = append(merged.Options, derived.Options...)
+
+	merged.LoadOrder.Mods = append(append([]ModOrderRule{}, base.LoadOrder.Mods...), derived.LoadOrder.Mods...)
+	merged.LoadOrder.Plugins = append(append([]PluginOrderRule{}, base.LoadOrder.Plugins...), derived.LoadOrder.Plugins...)
+
+	downloads, errs := mergeSteps("downloadSteps", base.DownloadSteps, derived.DownloadSteps, downloadStepKey,
+		func(step DownloadStep) bool { return step.Remove },
+		func(step DownloadStep) string { return step.InsertBefore })
+
+	baseUnpackSteps := remapUnpackSteps(base.DownloadSteps, downloads, base.UnpackSteps)
+	unpacks, unpackErrs := mergeSteps("unpackSteps", baseUnpackSteps, derived.UnpackSteps, unpackStepKey,
+		func(step UnpackStep) bool { return step.Remove },
+		func(step UnpackStep) string { return step.InsertBefore })
+	errs = append(errs, unpackErrs...)
+
+	merged.DownloadSteps = []DownloadStep{}
+	for _, entry := range downloads {
+		entry.step.InsertBefore = ""
+		merged.DownloadSteps = append(merged.DownloadSteps, entry.step)
+	}
+	merged.UnpackSteps = []UnpackStep{}
+	for _, entry := range unpacks {
+		entry.step.InsertBefore = ""
+		merged.UnpackSteps = append(merged.UnpackSteps, entry.step)
+	}
+
+	return merged, errs
+}
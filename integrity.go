@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Download steps can pin the size and sha256 of their archive. Archives that don't
+// match are offered for download again, and are never extracted.
+
+// FileSha256 hashes a file as lowercase hex
+func FileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// findDownloadStep returns the download step a manifest record was downloaded for
+func findDownloadStep(steps []DownloadStep, record ManifestRecord) (DownloadStep, bool) {
+	for _, step := range steps {
+		if step.ModId == record.ModId && step.SiteFileName == record.FileDisplayName {
+			return step, true
+		}
+	}
+	return DownloadStep{}, false
+}
+
+// CheckArchive compares a downloaded archive against the pins of its step. The size and
+// hash found are stored in the record, hashing only when the step pins one or the record has none yet.
+// An archive whose size or hash changed since they were recorded doesn't match either.
+func CheckArchive(path string, step DownloadStep, record *ManifestRecord) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if step.Size > 0 && info.Size() != step.Size {
+		return fmt.Errorf("%s is %d bytes, the preset expects %d", record.FileName, info.Size(), step.Size)
+	}
+	if record.Size > 0 && info.Size() != record.Size {
+		return fmt.Errorf("%s is %d bytes, but was %d bytes when it was downloaded", record.FileName, info.Size(), record.Size)
+	}
+	record.Size = info.Size()
+
+	if step.Sha256 == "" && record.Sha256 != "" {
+		return nil
+	}
+	hash, err := FileSha256(path)
+	if err != nil {
+		return err
+	}
+	if step.Sha256 != "" && !strings.EqualFold(hash, step.Sha256) {
+		return fmt.Errorf("%s has sha256 %s, the preset expects %s", record.FileName, hash, strings.ToLower(step.Sha256))
+	}
+	if record.Sha256 != "" && hash != record.Sha256 {
+		return fmt.Errorf("%s has sha256 %s, but had %s when it was downloaded", record.FileName, hash, record.Sha256)
+	}
+	record.Sha256 = hash
+	return nil
+}
+
+// askYesNo asks a question on the terminal, anything but y or yes is a no
+func askYesNo(question string) bool {
+	fmt.Print(question, " [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// isSha256 reports whether a pinned hash is 64 hex characters
+func isSha256(value string) bool {
+	if len(value) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ModOrderRule moves the data directories of a mod before or after those of other mods.
+// Data or FileIndex narrow the rule down to some of the mod's data directories, ie a patch folder.
+type ModOrderRule struct {
+	Mod       int32   `yaml:"mod"`
+	Data      string  `yaml:"data,omitempty"`      // only the data directory with this path in the archive
+	FileIndex *int16  `yaml:"fileIndex,omitempty"` // only the data directories of this download of the mod
+	Before    []int32 `yaml:"before"`
+	After     []int32 `yaml:"after"`
+}
+
+// Selects reports whether the rule is about a data directory of its mod
+func (rule ModOrderRule) Selects(owner DataOwner) bool {
+	if owner.ModId != rule.Mod {
+		return false
+	}
+	if rule.FileIndex != nil && owner.FileIndex != *rule.FileIndex {
+		return false
+	}
+	return rule.Data == "" || strings.EqualFold(cleanArchivePath(rule.Data), cleanArchivePath(owner.Data))
+}
+
+// DataOwner is a data directory and the DATA step that added it
+type DataOwner struct {
+	Path      string
+	ModId     int32
+	FileIndex int16
+	Data      string // path of the directory in the archive
+}
+
+type PluginOrderRule struct {
+	Plugin string   `yaml:"plugin"`
+	Before []string `yaml:"before"`
+	After  []string `yaml:"after"`
+}
+
+// LoadOrderRules are before/after constraints that the data and content lists are sorted by
+type LoadOrderRules struct {
+	Mods    []ModOrderRule    `yaml:"mods"`
+	Plugins []PluginOrderRule `yaml:"plugins"`
+}
+
+// ContentProblem is a plugin of the content list that won't load cleanly
+type ContentProblem struct {
+	Plugin  string
+	Message string
+}
+
+func (problem ContentProblem) String() string {
+	return fmt.Sprint(problem.Plugin, " ", problem.Message)
+}
+
+// ReadContentMasters reads the masters of every content plugin that can be found in the data directories.
+// Plugins are keyed by lower case name, since OpenMW ignores case.
+func ReadContentMasters(cfg *OpenMWConfig) (map[string][]string, []ContentProblem) {
+	masters := make(map[string][]string)
+	problems := []ContentProblem{}
+	for _, plugin := range cfg.Values(CFG_CONTENT) {
+		if !hasExts(plugin, PLUGIN_EXTS) {
+			continue
+		}
+		path, found := FindInDataDirs(cfg, plugin)
+		if !found {
+			if !sliceContains(CONTENT_FILTERS, plugin) {
+				problems = append(problems, ContentProblem{Plugin: plugin, Message: "was not found in any data directory"})
+			}
+			continue
+		}
+		header, err := ReadPluginHeader(path)
+		if err != nil {
+			problems = append(problems, ContentProblem{Plugin: plugin, Message: fmt.Sprint("could not be read: ", err.Error())})
+			continue
+		}
+		masters[strings.ToLower(plugin)] = header.MasterNames()
+	}
+	return masters, problems
+}
+
+// CheckContentMasters reports masters that are missing or load after the plugins that need them.
+// The game plugins count as loaded first, since they come from the base openmw.cfg.
+func CheckContentMasters(content []string, masters map[string][]string) []ContentProblem {
+	problems := []ContentProblem{}
+	position := make(map[string]int)
+	for i, plugin := range content {
+		position[strings.ToLower(plugin)] = i
+	}
+
+	for i, plugin := range content {
+		for _, master := range masters[strings.ToLower(plugin)] {
+			masterPosition, loaded := position[strings.ToLower(master)]
+			if !loaded {
+				if !containsFold(CONTENT_FILTERS, master) {
+					problems = append(problems, ContentProblem{Plugin: plugin, Message: fmt.Sprint("needs master ", master, ", which is not in the content list")})
+				}
+			} else if masterPosition > i {
+				problems = append(problems, ContentProblem{Plugin: plugin, Message: fmt.Sprint("loads before its master ", master)})
+			}
+		}
+	}
+	return problems
+}
+
+// OrderEdge says First has to load before Then
+type OrderEdge struct {
+	First string
+	Then  string
+}
+
+// SortByRules orders items so every edge holds, keeping the original order wherever the rules allow.
+// Names are compared without case. Edges between items caught in a cycle can't all hold, so the
+// cycle is broken at its earliest item, and the items of the cycle are also returned to be reported.
+func SortByRules(items []string, edges []OrderEdge) ([]string, []string) {
+	positions := make(map[string][]int)
+	for i, item := range items {
+		key := strings.ToLower(item)
+		positions[key] = append(positions[key], i)
+	}
+
+	next := make([][]int, len(items))
+	previous := make([][]int, len(items))
+	inDegree := make([]int, len(items))
+	seen := make(map[[2]int]bool)
+	for _, edge := range edges {
+		for _, first := range positions[strings.ToLower(edge.First)] {
+			for _, then := range positions[strings.ToLower(edge.Then)] {
+				if first == then || seen[[2]int{first, then}] {
+					continue
+				}
+				seen[[2]int{first, then}] = true
+				next[first] = append(next[first], then)
+				previous[then] = append(previous[then], first)
+				inDegree[then]++
+			}
+		}
+	}
+	components, sizes := stronglyConnected(next)
+
+	// always take the earliest item that has nothing left to wait for, or that only waits for
+	// the other items of its cycle, so a cycle stays where it was
+	sorted := []string{}
+	done := make([]bool, len(items))
+	for len(sorted) < len(items) {
+		pick := -1
+		for i := range items {
+			if done[i] {
+				continue
+			}
+			if inDegree[i] == 0 || (sizes[components[i]] > 1 && onlyWaitsForComponent(i, previous, done, components)) {
+				pick = i
+				break
+			}
+		}
+		for i := 0; pick < 0; i++ {
+			if !done[i] {
+				pick = i
+			}
+		}
+		done[pick] = true
+		sorted = append(sorted, items[pick])
+		for _, then := range next[pick] {
+			inDegree[then]--
+		}
+	}
+
+	cycle := []string{}
+	for i, item := range items {
+		if sizes[components[i]] > 1 {
+			cycle = append(cycle, item)
+		}
+	}
+	return sorted, cycle
+}
+
+func onlyWaitsForComponent(item int, previous [][]int, done []bool, components []int) bool {
+	for _, first := range previous[item] {
+		if !done[first] && components[first] != components[item] {
+			return false
+		}
+	}
+	return true
+}
+
+// stronglyConnected numbers the strongly connected components of a graph (Tarjan), items that
+// share a component of more than one item are in a cycle
+func stronglyConnected(next [][]int) ([]int, []int) {
+	components := make([]int, len(next))
+	sizes := []int{}
+	index := make([]int, len(next))
+	low := make([]int, len(next))
+	onStack := make([]bool, len(next))
+	stack := []int{}
+	counter := 0
+	for i := range index {
+		index[i] = -1
+	}
+
+	var visit func(item int)
+	visit = func(item int) {
+		index[item] = counter
+		low[item] = counter
+		counter++
+		stack = append(stack, item)
+		onStack[item] = true
+		for _, then := range next[item] {
+			if index[then] < 0 {
+				visit(then)
+				if low[then] < low[item] {
+					low[item] = low[then]
+				}
+			} else if onStack[then] && index[then] < low[item] {
+				low[item] = index[then]
+			}
+		}
+		if low[item] != index[item] {
+			return
+		}
+		size := 0
+		for {
+			member := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[member] = false
+			components[member] = len(sizes)
+			size++
+			if member == item {
+				break
+			}
+		}
+		sizes = append(sizes, size)
+	}
+	for i := range next {
+		if index[i] < 0 {
+			visit(i)
+		}
+	}
+	return components, sizes
+}
+
+// OrderViolations lists the edges that the current order breaks
+func OrderViolations(items []string, edges []OrderEdge) []OrderEdge {
+	position := make(map[string]int)
+	for i, item := range items {
+		position[strings.ToLower(item)] = i
+	}
+	violations := []OrderEdge{}
+	for _, edge := range edges {
+		first, hasFirst := position[strings.ToLower(edge.First)]
+		then, hasThen := position[strings.ToLower(edge.Then)]
+		if hasFirst && hasThen && first > then {
+			violations = append(violations, edge)
+		}
+	}
+	return violations
+}
+
+func masterEdges(masters map[string][]string) []OrderEdge {
+	edges := []OrderEdge{}
+	for plugin, pluginMasters := range masters {
+		for _, master := range pluginMasters {
+			edges = append(edges, OrderEdge{First: master, Then: plugin})
+		}
+	}
+	return edges
+}
+
+// SortContentByMasters moves masters in front of the plugins that need them, keeping every other plugin in place
+func SortContentByMasters(content []string, masters map[string][]string) []string {
+	sorted, _ := SortByRules(content, masterEdges(masters))
+	return sorted
+}
+
+// PluginOrderEdges turns the plugin rules of a preset into edges
+func PluginOrderEdges(rules []PluginOrderRule) []OrderEdge {
+	edges := []OrderEdge{}
+	for _, rule := range rules {
+		for _, before := range rule.Before {
+			edges = append(edges, OrderEdge{First: rule.Plugin, Then: before})
+		}
+		for _, after := range rule.After {
+			edges = append(edges, OrderEdge{First: after, Then: rule.Plugin})
+		}
+	}
+	return edges
+}
+
+// ModOrderEdges turns the mod rules of a preset into edges between data directories. A rule
+// moves the data directories it selects, relative to every data directory of the listed mods.
+func ModOrderEdges(rules []ModOrderRule, owners []DataOwner) []OrderEdge {
+	edges := []OrderEdge{}
+	link := func(first []string, then []string) {
+		for _, firstPath := range first {
+			for _, thenPath := range then {
+				edges = append(edges, OrderEdge{First: firstPath, Then: thenPath})
+			}
+		}
+	}
+	modData := func(modId int32) []string {
+		return ownedDataPaths(owners, ModOrderRule{Mod: modId})
+	}
+	for _, rule := range rules {
+		selected := ownedDataPaths(owners, rule)
+		for _, before := range rule.Before {
+			link(selected, modData(before))
+		}
+		for _, after := range rule.After {
+			link(modData(after), selected)
+		}
+	}
+	return edges
+}
+
+func ownedDataPaths(owners []DataOwner, rule ModOrderRule) []string {
+	paths := []string{}
+	for _, owner := range owners {
+		if rule.Selects(owner) {
+			paths = append(paths, owner.Path)
+		}
+	}
+	return paths
+}
+
+// sortConfigKey sorts the values of an openmw.cfg key, reporting what had to move
+func sortConfigKey(cfg *OpenMWConfig, key string, edges []OrderEdge) {
+	values := cfg.Values(key)
+	for _, violation := range OrderViolations(values, edges) {
+		fmt.Println(fmt.Sprint("Moving ", key, "=", violation.First, " in front of ", violation.Then))
+	}
+	sorted, cycle := SortByRules(values, edges)
+	if len(cycle) > 0 {
+		fmt.Println(fmt.Sprint("Warning: load order rules form a cycle between ", key, " entries, they keep their order: ", strings.Join(cycle, ", ")))
+	}
+	cfg.SetValues(key, sorted)
+}
+
+// ApplyLoadOrder sorts data and content entries by the preset's load order rules, then checks plugin masters.
+// Masters are only used for sorting when the preset sets sortContent.
+func ApplyLoadOrder(cfg *OpenMWConfig, config ModListConfig, dataOwners []DataOwner) {
+	sortConfigKey(cfg, CFG_DATA, ModOrderEdges(config.LoadOrder.Mods, dataOwners))
+
+	masters, problems := ReadContentMasters(cfg)
+	edges := PluginOrderEdges(config.LoadOrder.Plugins)
+	if config.SortContent {
+		edges = append(edges, masterEdges(masters)...)
+	}
+	sortConfigKey(cfg, CFG_CONTENT, edges)
+
+	problems = append(problems, CheckContentMasters(cfg.Values(CFG_CONTENT), masters)...)
+	for _, problem := range problems {
+		fmt.Println(fmt.Sprint("Warning: ", problem.String()))
+	}
+}
+
+func containsFold(slice []string, val string) bool {
+	for _, value := range slice {
+		if strings.EqualFold(value, val) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortByRulesKeepsCycleInPlace(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+	// b and c form a cycle, d has to load after c, e has no rules
+	edges := []OrderEdge{{First: "b", Then: "c"}, {First: "c", Then: "b"}, {First: "c", Then: "d"}}
+	sorted, cycle := SortByRules(items, edges)
+	if !reflect.DeepEqual(sorted, items) {
+		t.Errorf("sorted = %v, want %v", sorted, items)
+	}
+	if !reflect.DeepEqual(cycle, []string{"b", "c"}) {
+		t.Errorf("cycle = %v, want [b c]", cycle)
+	}
+}
+
+func TestSortByRulesMovesItems(t *testing.T) {
+	sorted, cycle := SortByRules([]string{"a", "B", "c"}, []OrderEdge{{First: "c", Then: "b"}})
+	if want := []string{"a", "c", "B"}; !reflect.DeepEqual(sorted, want) {
+		t.Errorf("sorted = %v, want %v", sorted, want)
+	}
+	if len(cycle) > 0 {
+		t.Errorf("cycle = %v, want none", cycle)
+	}
+}
+
+func TestModOrderEdgesSelectData(t *testing.T) {
+	owners := []DataOwner{
+		{Path: "bcom/00 Core", ModId: 49231, FileIndex: 0, Data: "00 Core"},
+		{Path: "bcom/01 Waterworks Core", ModId: 49231, FileIndex: 0, Data: "01 Waterworks Core"},
+		{Path: "patches/79 Main Quest Overhaul", ModId: 49231, FileIndex: 1, Data: "79 Main Quest Overhaul"},
+		{Path: "mqo", ModId: 46913, FileIndex: 0, Data: ""},
+	}
+	patches := int16(1)
+	rules := []ModOrderRule{
+		{Mod: 49231, Data: "00 Core/", Before: []int32{46913}},
+		{Mod: 49231, FileIndex: &patches, After: []int32{46913}},
+	}
+	edges := ModOrderEdges(rules, owners)
+	want := []OrderEdge{{First: "bcom/00 Core", Then: "mqo"}, {First: "mqo", Then: "patches/79 Main Quest Overhaul"}}
+	if !reflect.DeepEqual(edges, want) {
+		t.Fatalf("edges = %v, want %v", edges, want)
+	}
+
+	items := []string{"patches/79 Main Quest Overhaul", "mqo", "bcom/01 Waterworks Core", "bcom/00 Core"}
+	sorted, cycle := SortByRules(items, edges)
+	if want := []string{"bcom/01 Waterworks Core", "bcom/00 Core", "mqo", "patches/79 Main Quest Overhaul"}; !reflect.DeepEqual(sorted, want) {
+		t.Errorf("sorted = %v, want %v", sorted, want)
+	}
+	if len(cycle) > 0 {
+		t.Errorf("cycle = %v, want none", cycle)
+	}
+}
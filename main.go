@@ -8,6 +8,12 @@ func main() {
 	// decide to run UI or CMD process
 	if len(os.Args) == 0 {
 		// run visual UI
+	} else if len(os.Args) > 1 && os.Args[1] == "validate" {
+		RunValidate(os.Args[2:])
+	} else if len(os.Args) > 1 && os.Args[1] == "outdated" {
+		RunOutdated(os.Args[2:])
+	} else if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		RunReconcile(os.Args[2:])
 	} else {
 		// run command line
 		RunTerminal()
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// With an API key in preferences, Nexus downloads use the REST API instead of the browser.
+// Download links are only handed out to premium accounts, others fall back to the browser.
+
+const NEXUS_API_URL = "https://api.nexusmods.com"
+const NEXUS_GAME = "morrowind"
+
+type NexusFile struct {
+	FileId       int64  `json:"file_id"`
+	Name         string `json:"name"`      // display name on the files tab, what siteFileName matches
+	FileName     string `json:"file_name"` // name of the archive
+	Version      string `json:"version"`
+	CategoryName string `json:"category_name"`
+	Size         int64  `json:"size_in_bytes"`
+}
+
+// NexusFileUpdate links a file to the file that replaced it
+type NexusFileUpdate struct {
+	OldFileId int64  `json:"old_file_id"`
+	NewFileId int64  `json:"new_file_id"`
+	NewName   string `json:"new_file_name"`
+}
+
+type NexusFileList struct {
+	Files   []NexusFile       `json:"files"`
+	Updates []NexusFileUpdate `json:"file_updates"`
+}
+
+type nexusDownloadLink struct {
+	Name string `json:"name"`
+	URI  string `json:"URI"`
+}
+
+type NexusApi struct {
+	BaseUrl    string
+	ApiKey     string
+	Downloader Downloader
+}
+
+// NewNexusApi returns nil when no API key is set
+func NewNexusApi(prefs PreferencesConfig) *NexusApi {
+	if prefs.NexusApiKey == "" {
+		return nil
+	}
+	baseUrl := prefs.NexusApiUrl
+	if baseUrl == "" {
+		baseUrl = NEXUS_API_URL
+	}
+	return &NexusApi{BaseUrl: strings.TrimSuffix(baseUrl, "/"), ApiKey: prefs.NexusApiKey, Downloader: NewDownloader()}
+}
+
+func (api NexusApi) getJson(path string, value any) error {
+	request, err := http.NewRequest(http.MethodGet, fmt.Sprint(api.BaseUrl, path), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("apikey", api.ApiKey)
+	request.Header.Set("User-Agent", api.Downloader.UserAgent)
+	request.Header.Set("Accept", "application/json")
+
+	response, err := api.Downloader.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("nexus api %s: %s", path, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+func (api NexusApi) ListFiles(modId int32) (NexusFileList, error) {
+	list := NexusFileList{}
+	err := api.getJson(fmt.Sprint("/v1/games/", NEXUS_GAME, "/mods/", modId, "/files.json"), &list)
+	return list, err
+}
+
+// categories of files that are still listed, but no longer current
+var NEXUS_OLD_CATEGORIES = []string{"OLD_VERSION", "ARCHIVED"}
+
+// FindNexusFile matches a step by fileId when it has one, otherwise by siteFileName.
+// An exact name wins, a name containing siteFileName is used when it is the only one, like the browser does.
+// A pinned version has to match, and files that moved to old files are reported.
+func FindNexusFile(files []NexusFile, step DownloadStep) (NexusFile, error) {
+	file, err := findNexusFile(files, step)
+	if err != nil {
+		return file, err
+	}
+	if step.Version != "" && file.Version != step.Version {
+		return file, fmt.Errorf("%s is version %s, the preset pins version %s", step.SiteFileName, file.Version, step.Version)
+	}
+	if sliceContains(NEXUS_OLD_CATEGORIES, file.CategoryName) {
+		fmt.Println(fmt.Sprint("Warning: ", step.SiteFileName, " has moved to Old files, there may be a newer version"))
+	}
+	return file, nil
+}
+
+func findNexusFile(files []NexusFile, step DownloadStep) (NexusFile, error) {
+	if step.FileId > 0 {
+		for _, file := range files {
+			if file.FileId == step.FileId {
+				return file, nil
+			}
+		}
+		return NexusFile{}, fmt.Errorf("file %d (%s) of mod %d is no longer listed, it may have been removed or replaced", step.FileId, step.SiteFileName, step.ModId)
+	}
+
+	partial := []NexusFile{}
+	for _, file := range files {
+		if file.Name == step.SiteFileName {
+			return file, nil
+		}
+		if strings.Contains(file.Name, step.SiteFileName) {
+			partial = append(partial, file)
+		}
+	}
+	if len(partial) == 1 {
+		return partial[0], nil
+	}
+	if len(partial) > 1 {
+		return NexusFile{}, fmt.Errorf("%d files of mod %d match %q, set fileId on the step", len(partial), step.ModId, step.SiteFileName)
+	}
+	return NexusFile{}, fmt.Errorf("mod %d has no file named %q", step.ModId, step.SiteFileName)
+}
+
+func (api NexusApi) DownloadLinks(modId int32, fileId int64) ([]nexusDownloadLink, error) {
+	links := []nexusDownloadLink{}
+	err := api.getJson(fmt.Sprint("/v1/games/", NEXUS_GAME, "/mods/", modId, "/files/", fileId, "/download_link.json"), &links)
+	return links, err
+}
+
+// Download fetches the file of a nexus step into the download folder
+func (api NexusApi) Download(step DownloadStep, downloadFolder string) (DownloadedFile, error) {
+	list, err := api.ListFiles(step.ModId)
+	if err != nil {
+		return DownloadedFile{}, err
+	}
+	file, err := FindNexusFile(list.Files, step)
+	if err != nil {
+		return DownloadedFile{}, err
+	}
+	links, err := api.DownloadLinks(step.ModId, file.FileId)
+	if err != nil {
+		return DownloadedFile{}, err
+	}
+
+	for _, link := range links {
+		if err = api.Downloader.Download(link.URI, fmt.Sprint(downloadFolder, "/", file.FileName)); err == nil {
+			return DownloadedFile{FileName: file.FileName, FileId: file.FileId, Version: file.Version}, nil
+		}
+		fmt.Println(fmt.Sprint("Download from ", link.Name, " failed: ", err))
+	}
+	if err == nil {
+		err = fmt.Errorf("no download links for %s", file.FileName)
+	}
+	return DownloadedFile{}, err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindNexusFile(t *testing.T) {
+	files := testNexusFiles().Files
+	tests := []struct {
+		name    string
+		step    DownloadStep
+		fileId  int64
+		problem string
+	}{
+		{"by id", DownloadStep{SiteFileName: "Renamed", FileId: 1003}, 1003, ""},
+		{"removed id", DownloadStep{ModId: 42, SiteFileName: "Core", FileId: 800}, 0, "file 800 (Core) of mod 42 is no longer listed"},
+		{"exact name", DownloadStep{SiteFileName: "Core"}, 1001, ""},
+		{"only name containing", DownloadStep{SiteFileName: "Tamriel Data"}, 1002, ""},
+		{"exact name containing another", DownloadStep{ModId: 42, SiteFileName: "Textures HD"}, 1003, ""},
+		{"several names containing", DownloadStep{ModId: 42, SiteFileName: "e"}, 0, `files of mod 42 match "e"`},
+		{"no name", DownloadStep{ModId: 42, SiteFileName: "Music"}, 0, `mod 42 has no file named "Music"`},
+		{"pinned version", DownloadStep{SiteFileName: "Sounds", Version: "1.2"}, 1004, ""},
+		{"changed version", DownloadStep{SiteFileName: "Sounds", Version: "1.1"}, 0, "Sounds is version 1.2, the preset pins version 1.1"},
+		{"pinned id and version", DownloadStep{SiteFileName: "Core", FileId: 900, Version: "1.0"}, 900, ""},
+	}
+	for _, test := range tests {
+		file, err := FindNexusFile(files, test.step)
+		if test.problem != "" {
+			if err == nil || !strings.Contains(err.Error(), test.problem) {
+				t.Errorf("%s: error = %v, want %q", test.name, err, test.problem)
+			}
+			continue
+		}
+		if err != nil || file.FileId != test.fileId {
+			t.Errorf("%s: file %d, %v, want %d", test.name, file.FileId, err, test.fileId)
+		}
+	}
+}
+
+// testNexusApi serves testNexusFiles for mod 42, and hands out two download links for
+// every file, the first of them broken
+func testNexusApi(t *testing.T) PreferencesConfig {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	checkKey := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			if request.Header.Get("apikey") != "test-key" {
+				http.Error(writer, "no api key", http.StatusUnauthorized)
+				return
+			}
+			handler(writer, request)
+		}
+	}
+	mux.HandleFunc("/v1/games/morrowind/mods/42/files.json", checkKey(func(writer http.ResponseWriter, request *http.Request) {
+		json.NewEncoder(writer).Encode(testNexusFiles())
+	}))
+	mux.HandleFunc("/v1/games/morrowind/mods/42/files/", checkKey(func(writer http.ResponseWriter, request *http.Request) {
+		links := []nexusDownloadLink{
+			{Name: "Broken", URI: server.URL + "/cdn/missing"},
+			{Name: "Working", URI: server.URL + "/cdn/archive"},
+		}
+		json.NewEncoder(writer).Encode(links)
+	}))
+	mux.HandleFunc("/cdn/archive", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write(testArchive)
+	})
+	return PreferencesConfig{NexusApiKey: "test-key", NexusApiUrl: server.URL + "/"}
+}
+
+func TestNexusApiDownload(t *testing.T) {
+	prefs := testNexusApi(t)
+	api := NewNexusApi(prefs)
+	if api == nil {
+		t.Fatal("no api with a key set")
+	}
+	folder := t.TempDir()
+	file, err := api.Download(DownloadStep{Type: NEXUS, ModId: 42, SiteFileName: "Sounds"}, folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.FileName != "Sounds-42-1-2.7z" || file.FileId != 1004 || file.Version != "1.2" {
+		t.Errorf("file = %+v, want file 1004 of version 1.2", file)
+	}
+	checkDownloaded(t, filepath.Join(folder, file.FileName))
+
+	if _, err := api.Download(DownloadStep{Type: NEXUS, ModId: 42, SiteFileName: "Sounds", Version: "1.1"}, folder); err == nil {
+		t.Error("a file of another version was downloaded")
+	}
+	if _, err := api.Download(DownloadStep{Type: NEXUS, ModId: 7, SiteFileName: "Sounds"}, folder); err == nil {
+		t.Error("a mod the api doesn't list was downloaded")
+	}
+
+	prefs.NexusApiKey = "wrong-key"
+	if _, err := NewNexusApi(prefs).ListFiles(42); err == nil || !strings.Contains(err.Error(), fmt.Sprint(http.StatusUnauthorized)) {
+		t.Errorf("error = %v, want %d", err, http.StatusUnauthorized)
+	}
+	if NewNexusApi(PreferencesConfig{}) != nil {
+		t.Error("an api without a key")
+	}
+}
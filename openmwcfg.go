@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const CFG_DATA = "data"
+const CFG_DATA_LOCAL = "data-local"
+const CFG_CONTENT = "content"
+const CFG_GROUNDCOVER = "groundcover"
+const CFG_FALLBACK_ARCHIVE = "fallback-archive"
+const CFG_FALLBACK = "fallback"
+const CFG_RESOURCES = "resources"
+const CFG_ENCODING = "encoding"
+
+// keys holding paths, which openmw.cfg quotes and escapes with &
+var CFG_PATH_KEYS = []string{CFG_DATA, CFG_DATA_LOCAL, CFG_RESOURCES, "user-data", "config", "load-savegame"}
+
+// order new keys are placed in when the config doesn't have them yet
+var CFG_KEY_ORDER = []string{CFG_ENCODING, CFG_FALLBACK, CFG_FALLBACK_ARCHIVE, CFG_RESOURCES, CFG_DATA, CFG_DATA_LOCAL, CFG_CONTENT, CFG_GROUNDCOVER}
+
+// OpenMWConfigEntry is one line of openmw.cfg. Comments and blank lines have no key.
+type OpenMWConfigEntry struct {
+	Key   string
+	Value string // unescaped value
+	Raw   string // original text of comments and blank lines
+}
+
+func (entry OpenMWConfigEntry) IsSetting() bool {
+	return entry.Key != ""
+}
+
+func (entry OpenMWConfigEntry) String() string {
+	if !entry.IsSetting() {
+		return entry.Raw
+	}
+	if sliceContains(CFG_PATH_KEYS, entry.Key) {
+		return fmt.Sprint(entry.Key, "=", QuoteConfigPath(entry.Value))
+	}
+	return fmt.Sprint(entry.Key, "=", entry.Value)
+}
+
+// OpenMWConfig keeps every line of openmw.cfg in order, so it can be edited and written back as is
+type OpenMWConfig struct {
+	Entries []OpenMWConfigEntry
+}
+
+// QuoteConfigPath quotes a path the way openmw.cfg expects, escaping & and " with &
+func QuoteConfigPath(path string) string {
+	escaped := strings.ReplaceAll(path, "&", "&&")
+	escaped = strings.ReplaceAll(escaped, "\"", "&\"")
+	return fmt.Sprint("\"", escaped, "\"")
+}
+
+// UnquoteConfigPath reverses QuoteConfigPath. Unquoted paths are returned as they are.
+func UnquoteConfigPath(value string) string {
+	if !strings.HasPrefix(value, "\"") {
+		return value
+	}
+
+	var path strings.Builder
+	escaped := false
+	for _, char := range value[1:] {
+		if escaped {
+			path.WriteRune(char)
+			escaped = false
+		} else if char == '&' {
+			escaped = true
+		} else if char == '"' {
+			break
+		} else {
+			path.WriteRune(char)
+		}
+	}
+	return path.String()
+}
+
+// ParseConfigLine reads a single openmw.cfg line
+func ParseConfigLine(line string) OpenMWConfigEntry {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || !strings.Contains(trimmed, "=") {
+		return OpenMWConfigEntry{Raw: line}
+	}
+
+	parts := strings.SplitN(trimmed, "=", 2)
+	key := strings.TrimSpace(parts[0])
+	value := strings.TrimSpace(parts[1])
+	if sliceContains(CFG_PATH_KEYS, key) {
+		value = UnquoteConfigPath(value)
+	}
+	return OpenMWConfigEntry{Key: key, Value: value}
+}
+
+func ParseOpenMWConfig(reader io.Reader) (*OpenMWConfig, error) {
+	config := &OpenMWConfig{Entries: []OpenMWConfigEntry{}}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		config.Entries = append(config.Entries, ParseConfigLine(scanner.Text()))
+	}
+	return config, scanner.Err()
+}
+
+func ReadOpenMWConfig(path string) (*OpenMWConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseOpenMWConfig(file)
+}
+
+func (config *OpenMWConfig) Lines() []string {
+	lines := []string{}
+	for _, entry := range config.Entries {
+		lines = append(lines, entry.String())
+	}
+	return lines
+}
+
+func (config *OpenMWConfig) Write(path string) error {
+	return writeLines(config.Lines(), path)
+}
+
+// Values returns every value of a key in file order
+func (config *OpenMWConfig) Values(key string) []string {
+	values := []string{}
+	for _, entry := range config.Entries {
+		if entry.Key == key {
+			values = append(values, entry.Value)
+		}
+	}
+	return values
+}
+
+// Index returns the position of a key/value pair, or -1
+func (config *OpenMWConfig) Index(key string, value string) int {
+	for i, entry := range config.Entries {
+		if entry.Key == key && entry.Value == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func (config *OpenMWConfig) Has(key string, value string) bool {
+	return config.Index(key, value) >= 0
+}
+
+// Insert puts a setting at an exact position
+func (config *OpenMWConfig) Insert(index int, key string, value string) {
+	if index < 0 || index > len(config.Entries) {
+		index = len(config.Entries)
+	}
+	entry := OpenMWConfigEntry{Key: key, Value: value}
+	config.Entries = append(config.Entries[:index], append([]OpenMWConfigEntry{entry}, config.Entries[index:]...)...)
+}
+
+// appendIndex is where a new value of key goes: after the last value of the same key,
+// or in front of the first key that comes later in CFG_KEY_ORDER
+func (config *OpenMWConfig) appendIndex(key string) int {
+	last := -1
+	for i, entry := range config.Entries {
+		if entry.Key == key {
+			last = i
+		}
+	}
+	if last >= 0 {
+		return last + 1
+	}
+
+	keyRank := indexOf(CFG_KEY_ORDER, key)
+	if keyRank >= 0 {
+		for i, entry := range config.Entries {
+			if rank := indexOf(CFG_KEY_ORDER, entry.Key); rank > keyRank {
+				return i
+			}
+		}
+	}
+	return len(config.Entries)
+}
+
+// Add appends a value to the end of its key's list, unless it is already there
+func (config *OpenMWConfig) Add(key string, value string) bool {
+	if config.Has(key, value) {
+		return false
+	}
+	config.Insert(config.appendIndex(key), key, value)
+	return true
+}
+
+// Remove deletes every entry of a key/value pair
+func (config *OpenMWConfig) Remove(key string, value string) bool {
+	removed := false
+	entries := []OpenMWConfigEntry{}
+	for _, entry := range config.Entries {
+		if entry.Key == key && entry.Value == value {
+			removed = true
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	config.Entries = entries
+	return removed
+}
+
+// RemoveKey deletes every value of a key
+func (config *OpenMWConfig) RemoveKey(key string) {
+	entries := []OpenMWConfigEntry{}
+	for _, entry := range config.Entries {
+		if entry.Key != key {
+			entries = append(entries, entry)
+		}
+	}
+	config.Entries = entries
+}
+
+// SetValues reorders and replaces the values of a key. The new list takes the
+// place of the first existing value, other lines are left where they are.
+func (config *OpenMWConfig) SetValues(key string, values []string) {
+	index := -1
+	for i, entry := range config.Entries {
+		if entry.Key == key {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		index = config.appendIndex(key)
+	}
+
+	entries := []OpenMWConfigEntry{}
+	for i, entry := range config.Entries {
+		if i == index {
+			for _, value := range values {
+				entries = append(entries, OpenMWConfigEntry{Key: key, Value: value})
+			}
+		}
+		if entry.Key != key {
+			entries = append(entries, entry)
+		}
+	}
+	if index == len(config.Entries) {
+		for _, value := range values {
+			entries = append(entries, OpenMWConfigEntry{Key: key, Value: value})
+		}
+	}
+	config.Entries = entries
+}
+
+func indexOf(slice []string, val string) int {
+	for i, value := range slice {
+		if value == val {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestQuoteConfigPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		quoted string
+	}{
+		{"plain", "C:/Games/Morrowind/Data Files", `"C:/Games/Morrowind/Data Files"`},
+		{"ampersand", "/mods/Sounds & Music", `"/mods/Sounds && Music"`},
+		{"quote", `/mods/The "Best" Textures`, `"/mods/The &"Best&" Textures"`},
+		{"escaped quote", `/mods/&"Tricky"&`, `"/mods/&&&"Tricky&"&&"`},
+		{"empty", "", `""`},
+	}
+	for _, test := range tests {
+		quoted := QuoteConfigPath(test.path)
+		if quoted != test.quoted {
+			t.Errorf("%s: quoted = %s, want %s", test.name, quoted, test.quoted)
+		}
+		if path := UnquoteConfigPath(quoted); path != test.path {
+			t.Errorf("%s: unquoted = %q, want %q", test.name, path, test.path)
+		}
+	}
+}
+
+func TestUnquoteConfigPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		path  string
+	}{
+		{"unquoted", "/mods/Sounds & Music", "/mods/Sounds & Music"},
+		{"unquoted with quote", `/mods/The "Best"`, `/mods/The "Best"`},
+		{"ends at quote", `"/mods/Core" trailing`, "/mods/Core"},
+		{"unterminated", `"/mods/Core`, "/mods/Core"},
+	}
+	for _, test := range tests {
+		if path := UnquoteConfigPath(test.value); path != test.path {
+			t.Errorf("%s: path = %q, want %q", test.name, path, test.path)
+		}
+	}
+}
+
+func TestParseConfigLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		entry OpenMWConfigEntry
+	}{
+		{"blank", "   ", OpenMWConfigEntry{Raw: "   "}},
+		{"comment", "# data=\"/mods/Core\"", OpenMWConfigEntry{Raw: "# data=\"/mods/Core\""}},
+		{"no value", "not a setting", OpenMWConfigEntry{Raw: "not a setting"}},
+		{"content", "content=Tamriel_Data.esm", OpenMWConfigEntry{Key: CFG_CONTENT, Value: "Tamriel_Data.esm"}},
+		{"spaces around", "  content = Morrowind.esm  ", OpenMWConfigEntry{Key: CFG_CONTENT, Value: "Morrowind.esm"}},
+		{"equals in value", "fallback=Weather_Clear_Sky=0", OpenMWConfigEntry{Key: CFG_FALLBACK, Value: "Weather_Clear_Sky=0"}},
+		{"quoted data", `data="/mods/Sounds && Music"`, OpenMWConfigEntry{Key: CFG_DATA, Value: "/mods/Sounds & Music"}},
+		{"escaped quote", `data="/mods/The &"Best&" Textures"`, OpenMWConfigEntry{Key: CFG_DATA, Value: `/mods/The "Best" Textures`}},
+		{"unquoted data", "data=/mods/Data Files", OpenMWConfigEntry{Key: CFG_DATA, Value: "/mods/Data Files"}},
+		{"quotes kept off path keys", `content="Quoted.esp"`, OpenMWConfigEntry{Key: CFG_CONTENT, Value: `"Quoted.esp"`}},
+	}
+	for _, test := range tests {
+		entry := ParseConfigLine(test.line)
+		if entry != test.entry {
+			t.Errorf("%s: entry = %+v, want %+v", test.name, entry, test.entry)
+		}
+		if entry.IsSetting() && ParseConfigLine(entry.String()) != entry {
+			t.Errorf("%s: %q doesn't read back", test.name, entry.String())
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type PresetOption struct {
+	Name        string `yaml:"name"`
+	Default     bool   `yaml:"default"`
+	Description string `yaml:"description"`
+}
+
+// parseCondition splits an `if:` value into the option name and whether it is negated
+func parseCondition(condition string) (string, bool) {
+	condition = strings.TrimSpace(condition)
+	if strings.HasPrefix(condition, "!") {
+		return strings.TrimSpace(condition[1:]), true
+	}
+	return condition, false
+}
+
+func findOption(options []PresetOption, name string) (PresetOption, bool) {
+	for _, option := range options {
+		if option.Name == name {
+			return option, true
+		}
+	}
+	return PresetOption{}, false
+}
+
+// EvaluateCondition reports whether a step with the given `if:` value is enabled
+func EvaluateCondition(condition string, values map[string]bool) (bool, error) {
+	if strings.TrimSpace(condition) == "" {
+		return true, nil
+	}
+	name, negated := parseCondition(condition)
+	value, ok := values[name]
+	if !ok {
+		return false, fmt.Errorf("unknown option %q", name)
+	}
+	return value != negated, nil
+}
+
+// ResolveOptionValues starts from the preset defaults and applies the user's choices on top
+func ResolveOptionValues(options []PresetOption, choices map[string]bool) map[string]bool {
+	values := make(map[string]bool)
+	for _, option := range options {
+		values[option.Name] = option.Default
+	}
+	for name, value := range choices {
+		if _, ok := values[name]; !ok {
+			fmt.Println(fmt.Sprint("Ignoring unknown preset option ", name))
+			continue
+		}
+		values[name] = value
+	}
+	return values
+}
+
+// ApplyPresetOptions drops every step whose `if:` condition is off.
+// Unpack steps that use a skipped download are dropped as well.
+func ApplyPresetOptions(config ModListConfig, values map[string]bool) (ModListConfig, error) {
+	downloads := []mergedStep[DownloadStep]{}
+	for i, step := range config.DownloadSteps {
+		enabled, err := EvaluateCondition(step.If, values)
+		if err != nil {
+			return config, fmt.Errorf("download step %s: %v", downloadStepKey(step), err)
+		}
+		if enabled {
+			downloads = append(downloads, mergedStep[DownloadStep]{step: step, baseFrom: i})
+		}
+	}
+
+	unpacks := []UnpackStep{}
+	for i, step := range config.UnpackSteps {
+		enabled, err := EvaluateCondition(step.If, values)
+		if err != nil {
+			return config, fmt.Errorf("unpack step %d: %v", i, err)
+		}
+		if enabled {
+			unpacks = append(unpacks, step)
+		}
+	}
+
+	filtered := config
+	filtered.UnpackSteps = remapUnpackSteps(config.DownloadSteps, downloads, unpacks)
+	filtered.DownloadSteps = []DownloadStep{}
+	for _, entry := range downloads {
+		filtered.DownloadSteps = append(filtered.DownloadSteps, entry.step)
+	}
+	return filtered, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-rod/rod"
+)
+
+// `mw-aradir outdated` compares the manifest of a preset with what the mod sites list now.
+// Nexus files are read through the API when there is an API key, otherwise from the files tab
+// using the fileList of the site profile. GitHub releases are compared with the latest release.
+
+type OutdatedMod struct {
+	Step    DownloadStep
+	Record  ManifestRecord
+	Message string
+}
+
+func (mod OutdatedMod) String() string {
+	return fmt.Sprint(mod.Step.SiteFileName, " (", mod.Step.ModId, "): ", mod.Message)
+}
+
+// NexusFileSource lists the files of a mod, from the API or the files tab
+type NexusFileSource func(modId int32) (NexusFileList, error)
+
+// ScrapeNexusFiles reads the files of a mod from the files tab, with the fileList of a site profile.
+// The site doesn't show which file replaced which, so the list has no updates.
+func ScrapeNexusFiles(page *rod.Page, profile SiteProfile, modId int32) (NexusFileList, error) {
+	list := NexusFileList{}
+	fileList := profile.FileList
+	if fileList.Selector == "" {
+		return list, fmt.Errorf("site profile %s has no fileList", profile.Name)
+	}
+	if err := nextPage(page, profile.ModFilesUrl(modId)); err != nil {
+		return list, err
+	}
+	if _, err := page.Timeout(NEXUS_STAGE_TIMEOUT).Element(fileList.Selector); err != nil {
+		return list, fmt.Errorf("no files on %s: %w", profile.ModFilesUrl(modId), err)
+	}
+
+	result, err := page.Eval(`(selector, id, name, version, old) => Array.from(document.querySelectorAll(selector)).map(el => ({
+		id: el.getAttribute(id) || "",
+		name: el.getAttribute(name) || "",
+		version: version ? (el.getAttribute(version) || "") : "",
+		old: old ? el.closest(old) !== null : false,
+	}))`, fileList.Selector, fileList.Id, fileList.Name, fileList.Version, fileList.Old)
+	if err != nil {
+		return list, err
+	}
+	for _, row := range result.Value.Arr() {
+		file := NexusFile{Name: row.Get("name").Str(), Version: row.Get("version").Str()}
+		file.FileId, _ = strconv.ParseInt(row.Get("id").Str(), 10, 64)
+		if row.Get("old").Bool() {
+			file.CategoryName = NEXUS_OLD_CATEGORIES[0]
+		}
+		list.Files = append(list.Files, file)
+	}
+	return list, nil
+}
+
+// newestNexusFile follows the updates from a file to the last file that replaced it
+func newestNexusFile(list NexusFileList, fileId int64) (NexusFile, bool) {
+	seen := map[int64]bool{fileId: true}
+	for next := true; next; {
+		next = false
+		for _, update := range list.Updates {
+			if update.OldFileId == fileId && !seen[update.NewFileId] {
+				fileId = update.NewFileId
+				seen[fileId] = true
+				next = true
+				break
+			}
+		}
+	}
+	for _, file := range list.Files {
+		if file.FileId == fileId {
+			return file, true
+		}
+	}
+	return NexusFile{}, false
+}
+
+// currentNexusFile finds a file with the same name that isn't old, for sites that don't list updates
+func currentNexusFile(list NexusFileList, file NexusFile) (NexusFile, bool) {
+	for _, other := range list.Files {
+		if other.Name == file.Name && other.FileId != file.FileId && !sliceContains(NEXUS_OLD_CATEGORIES, other.CategoryName) {
+			return other, true
+		}
+	}
+	return NexusFile{}, false
+}
+
+// CheckNexusFile compares the downloaded file of a step with the files listed now.
+// It returns an empty message when the download is current.
+func CheckNexusFile(list NexusFileList, step DownloadStep, record ManifestRecord) string {
+	fileStep := step
+	if record.FileId > 0 {
+		fileStep.FileId = record.FileId
+	}
+	downloaded, err := findNexusFile(list.Files, fileStep)
+	if err != nil {
+		if newest, ok := newestNexusFile(list, fileStep.FileId); fileStep.FileId > 0 && ok {
+			return fmt.Sprintf("file %d was replaced by %s version %s (file %d)", fileStep.FileId, newest.Name, newest.Version, newest.FileId)
+		}
+		return err.Error()
+	}
+
+	old := sliceContains(NEXUS_OLD_CATEGORIES, downloaded.CategoryName)
+	newest, ok := newestNexusFile(list, downloaded.FileId)
+	if old && (!ok || newest.FileId == downloaded.FileId) {
+		newest, ok = currentNexusFile(list, downloaded)
+	}
+	if ok && newest.FileId != downloaded.FileId {
+		return fmt.Sprintf("%s has a newer file: %s version %s (file %d)", downloaded.Name, newest.Name, newest.Version, newest.FileId)
+	}
+	if old {
+		return fmt.Sprintf("%s has moved to Old files, there may be a newer version", downloaded.Name)
+	}
+
+	downloadedVersion := record.Version
+	if downloadedVersion == "" {
+		downloadedVersion = step.Version
+	}
+	if downloadedVersion != "" && downloaded.Version != downloadedVersion {
+		return fmt.Sprintf("version %s was downloaded, the site lists version %s", downloadedVersion, downloaded.Version)
+	}
+	return ""
+}
+
+// CheckGithubRelease compares the downloaded release of a step with the latest release
+func CheckGithubRelease(downloader Downloader, step DownloadStep, record ManifestRecord) (string, error) {
+	latestStep := step
+	latestStep.Tag = ""
+	latest, err := downloader.FindGithubRelease(latestStep)
+	if err != nil {
+		return "", err
+	}
+	downloadedVersion := record.Version
+	if downloadedVersion == "" {
+		downloadedVersion = step.Tag
+	}
+	if downloadedVersion == "" || downloadedVersion == latest.TagName {
+		return "", nil
+	}
+	if step.Tag != "" {
+		return fmt.Sprintf("pinned to release %s, the latest release is %s", step.Tag, latest.TagName), nil
+	}
+	return fmt.Sprintf("release %s was downloaded, the latest release is %s", downloadedVersion, latest.TagName), nil
+}
+
+// FindOutdatedMods checks every downloaded step of a preset. Steps that couldn't be checked are
+// returned as errors, url and local steps have nothing to compare with and are skipped.
+func FindOutdatedMods(steps []DownloadStep, manifest ManifestListConfig, nexusFiles NexusFileSource, downloader Downloader) ([]OutdatedMod, []error) {
+	outdated := []OutdatedMod{}
+	errs := []error{}
+	nexusLists := make(map[int32]NexusFileList)
+
+	for _, record := range manifest.Records {
+		step, ok := findDownloadStep(steps, record)
+		if !ok {
+			continue
+		}
+		message := ""
+		var err error
+		switch step.Type {
+		case NEXUS:
+			list, listed := nexusLists[step.ModId]
+			if !listed {
+				list, err = nexusFiles(step.ModId)
+				if err != nil {
+					break
+				}
+				nexusLists[step.ModId] = list
+			}
+			message = CheckNexusFile(list, step, record)
+		case GITHUB_RELEASE:
+			message, err = CheckGithubRelease(downloader, step, record)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s (%d): %v", step.SiteFileName, step.ModId, err))
+		} else if message != "" {
+			outdated = append(outdated, OutdatedMod{Step: step, Record: record, Message: message})
+		}
+	}
+	return outdated, errs
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testNexusFiles is the file list of a mod whose files were replaced, moved to old files,
+// removed and uploaded again with a new version
+func testNexusFiles() NexusFileList {
+	return NexusFileList{
+		Files: []NexusFile{
+			{FileId: 1001, Name: "Core", FileName: "Core-42-2-0.7z", Version: "2.0", CategoryName: "MAIN"},
+			{FileId: 1002, Name: "Core Patch for Tamriel Data", FileName: "Core Patch TD-42-1-1.7z", Version: "1.1", CategoryName: "OPTIONAL"},
+			{FileId: 900, Name: "Core", FileName: "Core-42-1-0.7z", Version: "1.0", CategoryName: "OLD_VERSION"},
+			{FileId: 950, Name: "Textures", FileName: "Textures-42-1-0.7z", Version: "1.0", CategoryName: "OLD_VERSION"},
+			{FileId: 1003, Name: "Textures HD", FileName: "Textures-42-2-0.7z", Version: "2.0", CategoryName: "MAIN"},
+			{FileId: 700, Name: "Legacy", FileName: "Legacy-42-1-0.7z", Version: "1.0", CategoryName: "ARCHIVED"},
+			{FileId: 1004, Name: "Sounds", FileName: "Sounds-42-1-2.7z", Version: "1.2", CategoryName: "MAIN"},
+		},
+		Updates: []NexusFileUpdate{
+			{OldFileId: 900, NewFileId: 1001, NewName: "Core"},
+			{OldFileId: 800, NewFileId: 950, NewName: "Textures"},
+			{OldFileId: 950, NewFileId: 1003, NewName: "Textures HD"},
+		},
+	}
+}
+
+func TestCheckNexusFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    DownloadStep
+		record  ManifestRecord
+		message string // empty when current
+	}{
+		{"current", DownloadStep{SiteFileName: "Core", FileId: 1001}, ManifestRecord{FileId: 1001, Version: "2.0"}, ""},
+		{"current by name", DownloadStep{SiteFileName: "Sounds"}, ManifestRecord{Version: "1.2"}, ""},
+		{"replaced", DownloadStep{SiteFileName: "Core"}, ManifestRecord{FileId: 900, Version: "1.0"}, "Core has a newer file: Core version 2.0 (file 1001)"},
+		{"removed and replaced", DownloadStep{SiteFileName: "Textures"}, ManifestRecord{FileId: 800}, "file 800 was replaced by Textures HD version 2.0 (file 1003)"},
+		{"removed", DownloadStep{ModId: 42, SiteFileName: "Music"}, ManifestRecord{FileId: 600}, "file 600 (Music) of mod 42 is no longer listed"},
+		{"old", DownloadStep{SiteFileName: "Legacy"}, ManifestRecord{FileId: 700}, "Legacy has moved to Old files"},
+		{"re-versioned", DownloadStep{SiteFileName: "Sounds"}, ManifestRecord{FileId: 1004, Version: "1.1"}, "version 1.1 was downloaded, the site lists version 1.2"},
+		{"pinned version", DownloadStep{SiteFileName: "Sounds", Version: "1.0"}, ManifestRecord{FileId: 1004}, "version 1.0 was downloaded, the site lists version 1.2"},
+	}
+	for _, test := range tests {
+		message := CheckNexusFile(testNexusFiles(), test.step, test.record)
+		if test.message == "" && message != "" || !strings.HasPrefix(message, test.message) {
+			t.Errorf("%s: message = %q, want %q", test.name, message, test.message)
+		}
+	}
+}
+
+// the files tab doesn't list updates, an old file is compared with the current file of its name
+func TestCheckScrapedNexusFile(t *testing.T) {
+	list := testNexusFiles()
+	list.Updates = nil
+	message := CheckNexusFile(list, DownloadStep{SiteFileName: "Core"}, ManifestRecord{FileId: 900})
+	if message != "Core has a newer file: Core version 2.0 (file 1001)" {
+		t.Errorf("message = %q", message)
+	}
+}
+
+// testGithubApi serves the latest release of test/mod, other repositories are not found
+func testGithubApi(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/repos/test/mod/releases/latest" {
+			http.NotFound(writer, request)
+			return
+		}
+		fmt.Fprint(writer, `{"tag_name": "v2.0", "assets": [{"name": "mod.zip", "browser_download_url": "https://example.com/mod.zip"}]}`)
+	}))
+	previous := GITHUB_API_URL
+	GITHUB_API_URL = server.URL
+	t.Cleanup(func() {
+		GITHUB_API_URL = previous
+		server.Close()
+	})
+}
+
+func TestCheckGithubRelease(t *testing.T) {
+	testGithubApi(t)
+	tests := []struct {
+		name    string
+		step    DownloadStep
+		record  ManifestRecord
+		message string
+	}{
+		{"latest", DownloadStep{Repo: "test/mod"}, ManifestRecord{Version: "v2.0"}, ""},
+		{"unknown version", DownloadStep{Repo: "test/mod"}, ManifestRecord{}, ""},
+		{"newer release", DownloadStep{Repo: "test/mod"}, ManifestRecord{Version: "v1.0"}, "release v1.0 was downloaded, the latest release is v2.0"},
+		{"pinned", DownloadStep{Repo: "test/mod", Tag: "v1.0"}, ManifestRecord{}, "pinned to release v1.0, the latest release is v2.0"},
+	}
+	for _, test := range tests {
+		message, err := CheckGithubRelease(NewDownloader(), test.step, test.record)
+		if err != nil || message != test.message {
+			t.Errorf("%s: message = %q, %v, want %q", test.name, message, err, test.message)
+		}
+	}
+	if _, err := CheckGithubRelease(NewDownloader(), DownloadStep{Repo: "test/missing"}, ManifestRecord{Version: "v1.0"}); err == nil {
+		t.Error("a missing repository was checked")
+	}
+}
+
+func TestFindOutdatedMods(t *testing.T) {
+	testGithubApi(t)
+	steps := []DownloadStep{
+		{Type: NEXUS, ModId: 42, SiteFileName: "Core"},
+		{Type: NEXUS, ModId: 42, SiteFileName: "Sounds"},
+		{Type: NEXUS, ModId: 43, SiteFileName: "Hidden"},
+		{Type: GITHUB_RELEASE, Repo: "test/mod", SiteFileName: "Mod"},
+		{Type: URL, Url: "https://example.com/patch.zip", SiteFileName: "Patch"},
+	}
+	manifest := ManifestListConfig{Records: []ManifestRecord{
+		{FileName: "Core-42-1-0.zip", ModId: 42, FileDisplayName: "Core", FileId: 900},
+		{FileName: "Sounds-42-1-2.zip", ModId: 42, FileDisplayName: "Sounds", FileId: 1004, Version: "1.2"},
+		{FileName: "Hidden-43.zip", ModId: 43, FileDisplayName: "Hidden"},
+		{FileName: "mod.zip", FileDisplayName: "Mod", Version: "v1.0"},
+		{FileName: "patch.zip", FileDisplayName: "Patch"},
+		{FileName: "removed-from-preset.zip", ModId: 44, FileDisplayName: "Removed"},
+	}}
+
+	listed := map[int32]int{}
+	nexusFiles := func(modId int32) (NexusFileList, error) {
+		listed[modId]++
+		if modId != 42 {
+			return NexusFileList{}, fmt.Errorf("mod %d is hidden", modId)
+		}
+		return testNexusFiles(), nil
+	}
+
+	outdated, errs := FindOutdatedMods(steps, manifest, nexusFiles, NewDownloader())
+	if len(outdated) != 2 || outdated[0].Step.SiteFileName != "Core" || outdated[1].Step.SiteFileName != "Mod" {
+		t.Errorf("outdated = %v, want Core and Mod", outdated)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "Hidden (43)") {
+		t.Errorf("errors = %v, want Hidden", errs)
+	}
+	if listed[42] != 1 {
+		t.Errorf("the files of mod 42 were listed %d times, want once", listed[42])
+	}
+}
+
+func TestScrapeNexusFiles(t *testing.T) {
+	page, profile := testFilesPage(t)
+	list, err := ScrapeNexusFiles(page, profile, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []NexusFile{
+		{FileId: 1001, Name: "Core", Version: "2.0"},
+		{FileId: 1002, Name: "Core Patch for Tamriel Data", Version: "1.1"},
+		{FileId: 900, Name: "Core", Version: "1.0", CategoryName: NEXUS_OLD_CATEGORIES[0]},
+	}
+	if fmt.Sprint(list.Files) != fmt.Sprint(want) || len(list.Updates) != 0 {
+		t.Fatalf("files = %+v, want %+v", list.Files, want)
+	}
+	message := CheckNexusFile(list, DownloadStep{SiteFileName: "Core"}, ManifestRecord{FileId: 900})
+	if message != "Core has a newer file: Core version 2.0 (file 1001)" {
+		t.Errorf("message = %q", message)
+	}
+}
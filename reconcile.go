@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// `mw-aradir reconcile` rebuilds the manifest of a preset from the archives already in the
+// downloads folder, for a lost manifest or archives downloaded by hand. Archives are matched to
+// download steps by their name, and by peeking inside for the DATA folders the preset expects.
+
+// scores of the hints an archive gives, an archive needs RECONCILE_MIN_SCORE to match a step
+const RECONCILE_EXACT_NAME = 10   // the step names the file, ie fileName of url steps
+const RECONCILE_NEXUS_MOD_ID = 4  // a nexus file name carries the mod id of the step
+const RECONCILE_SITE_NAME = 3     // the name contains siteFileName
+const RECONCILE_DATA_FOLDERS = 3  // every DATA folder of the step is in the archive
+const RECONCILE_SHARED_WORDS = 2  // most words of siteFileName are in the name
+const RECONCILE_ORIGINAL_NAME = 1 // not a "name (1).zip" duplicate
+const RECONCILE_MIN_SCORE = 5
+
+// chrome saves a second download of a file as "name (1).zip"
+var DUPLICATE_DOWNLOAD = regexp.MustCompile(`^(.*) \(\d+\)(\.[^.]+)$`)
+
+// nexus names downloads "<name>-<modId>-<version>-<timestamp>.<ext>", with dashes in the version
+var NEXUS_FILE_VERSION = regexp.MustCompile(`^([0-9a-zA-Z-]*?)-?\d{9,}$`)
+
+// ReconcileMatch is an archive proposed as the download of a step
+type ReconcileMatch struct {
+	Step    DownloadStep
+	Record  ManifestRecord
+	Score   int
+	Reasons []string
+}
+
+// originalDownloadName removes the " (1)" chrome adds to duplicate downloads
+func originalDownloadName(fileName string) (string, bool) {
+	parts := DUPLICATE_DOWNLOAD.FindStringSubmatch(fileName)
+	if parts == nil {
+		return fileName, false
+	}
+	return fmt.Sprint(parts[1], parts[2]), true
+}
+
+// parseNexusFileName finds the mod id in the name nexus gives a download, and reads the version after it
+func parseNexusFileName(fileName string, modId int32) (string, bool) {
+	name := getFileName(fileName)
+	marker := fmt.Sprint("-", modId, "-")
+	at := strings.LastIndex(name, marker)
+	if at < 0 {
+		return "", false
+	}
+	parts := NEXUS_FILE_VERSION.FindStringSubmatch(name[at+len(marker):])
+	if parts == nil {
+		return "", false
+	}
+	return strings.ReplaceAll(parts[1], "-", "."), true
+}
+
+// nameWords splits a name into lowercase words, ignoring punctuation
+func nameWords(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// archiveHasFolder reports whether any entry is inside folder, ignoring case
+func archiveHasFolder(entries []string, folder string) bool {
+	folder = strings.Trim(strings.ReplaceAll(folder, "\\", "/"), "/")
+	if folder == "" || folder == "." {
+		return len(entries) > 0
+	}
+	prefix := strings.ToLower(folder) + "/"
+	for _, entry := range entries {
+		entry = strings.ToLower(entry)
+		if strings.HasPrefix(entry, prefix) || entry == strings.TrimSuffix(prefix, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// expectedDataFolders lists the DATA folders the unpack steps extract from a download step
+func expectedDataFolders(config ModListConfig, step DownloadStep) []string {
+	index := int16(0)
+	for _, other := range config.DownloadSteps {
+		if other.ModId != step.ModId {
+			continue
+		}
+		if other.SiteFileName == step.SiteFileName {
+			break
+		}
+		index++
+	}
+	folders := []string{}
+	for _, unpack := range config.UnpackSteps {
+		if unpack.Type == DATA && unpack.ModId == step.ModId && unpack.FileIndex == index {
+			folders = append(folders, unpack.Data...)
+		}
+	}
+	return folders
+}
+
+// scoreArchive rates how likely an archive is the download of a step. An archive
+// that is missing a DATA folder of the step, or has a different pinned size, never matches.
+func scoreArchive(step DownloadStep, fileName string, size int64, folders []string, entries func() ([]string, error)) (int, []string) {
+	if step.Size > 0 && size != step.Size {
+		return 0, nil
+	}
+	score := 0
+	reasons := []string{}
+	name, duplicate := originalDownloadName(fileName)
+	if !duplicate {
+		score += RECONCILE_ORIGINAL_NAME
+	}
+
+	switch step.Type {
+	case URL:
+		if strings.EqualFold(name, step.FileName) || strings.EqualFold(name, urlFileName(step.Url)) {
+			score += RECONCILE_EXACT_NAME
+			reasons = append(reasons, "file name")
+		}
+	case LOCAL:
+		if strings.EqualFold(name, filepath.Base(step.Path)) {
+			score += RECONCILE_EXACT_NAME
+			reasons = append(reasons, "file name")
+		}
+	case GITHUB_RELEASE:
+		if matched, _ := filepath.Match(step.Asset, name); matched {
+			score += RECONCILE_SITE_NAME
+			reasons = append(reasons, "asset pattern")
+		}
+	case NEXUS:
+		if _, ok := parseNexusFileName(name, step.ModId); ok {
+			score += RECONCILE_NEXUS_MOD_ID
+			reasons = append(reasons, "mod id")
+		}
+	}
+
+	siteWords := nameWords(step.SiteFileName)
+	fileWords := nameWords(getFileName(name))
+	if len(siteWords) > 0 && strings.Contains(strings.Join(fileWords, " "), strings.Join(siteWords, " ")) {
+		score += RECONCILE_SITE_NAME
+		reasons = append(reasons, "name")
+	} else if shared := len(siteWords) - len(missingWords(siteWords, fileWords)); len(siteWords) > 0 && shared*3 >= len(siteWords)*2 {
+		score += RECONCILE_SHARED_WORDS
+		reasons = append(reasons, "similar name")
+	}
+
+	// only archives that look right so far are opened
+	if len(folders) > 0 && score+RECONCILE_DATA_FOLDERS >= RECONCILE_MIN_SCORE {
+		list, err := entries()
+		if err != nil {
+			return 0, nil
+		}
+		for _, folder := range folders {
+			if !archiveHasFolder(list, folder) {
+				return 0, nil
+			}
+		}
+		score += RECONCILE_DATA_FOLDERS
+		reasons = append(reasons, "data folders")
+	}
+	return score, reasons
+}
+
+// extraNameWords counts the words of the archive name that are not in siteFileName
+func extraNameWords(match ReconcileMatch) int {
+	name, _ := originalDownloadName(match.Record.FileName)
+	return len(missingWords(nameWords(getFileName(name)), nameWords(match.Step.SiteFileName)))
+}
+
+func missingWords(words []string, in []string) []string {
+	missing := []string{}
+	for _, word := range words {
+		if !sliceContains(in, word) {
+			missing = append(missing, word)
+		}
+	}
+	return missing
+}
+
+// ReconcileDownloads matches the archives in the downloads folder to the steps that have no
+// record with an existing archive. Every archive and every step is used at most once, best scores first.
+func ReconcileDownloads(config ModListConfig, manifest ManifestListConfig, downloadFolder string) ([]ManifestRecord, []ReconcileMatch, []DownloadStep) {
+	kept := []ManifestRecord{}
+	used := make(map[string]bool)
+	done := make(map[string]bool)
+	for _, record := range manifest.Records {
+		if exists, _ := Exists(fmt.Sprint(downloadFolder, "/", record.FileName)); !exists {
+			continue
+		}
+		if step, ok := findDownloadStep(config.DownloadSteps, record); ok {
+			kept = append(kept, record)
+			used[record.FileName] = true
+			done[downloadStepKey(step)] = true
+		}
+	}
+
+	files, err := ioutil.ReadDir(downloadFolder)
+	checkError(err)
+	entryCache := make(map[string][]string)
+	candidates := []ReconcileMatch{}
+	for _, step := range config.DownloadSteps {
+		if done[downloadStepKey(step)] {
+			continue
+		}
+		folders := expectedDataFolders(config, step)
+		for _, file := range files {
+			fileName := file.Name()
+			if file.IsDir() || used[fileName] || !PathIncludesArchive(fileName) || filepath.Ext(fileName) == PARTIAL_EXT {
+				continue
+			}
+			path := fmt.Sprint(downloadFolder, "/", fileName)
+			entries := func() ([]string, error) {
+				if list, ok := entryCache[fileName]; ok {
+					return list, nil
+				}
+				list, err := ListArchive(path)
+				if err == nil {
+					entryCache[fileName] = list
+				}
+				return list, err
+			}
+			score, reasons := scoreArchive(step, fileName, file.Size(), folders, entries)
+			if score < RECONCILE_MIN_SCORE {
+				continue
+			}
+			record := ManifestRecord{FileName: fileName, ModId: step.ModId, FileDisplayName: step.SiteFileName}
+			if step.Type == NEXUS {
+				if version, ok := parseNexusFileName(fileName, step.ModId); ok {
+					record.Version = version
+				}
+			}
+			candidates = append(candidates, ReconcileMatch{Step: step, Record: record, Score: score, Reasons: reasons})
+		}
+	}
+
+	// on equal scores the archive with fewer words the step doesn't name wins, so "Core" doesn't
+	// take the archive of "Core Patch for Tamriel Data"
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return extraNameWords(candidates[i]) < extraNameWords(candidates[j])
+	})
+	matches := []ReconcileMatch{}
+	for _, candidate := range candidates {
+		key := downloadStepKey(candidate.Step)
+		if done[key] || used[candidate.Record.FileName] {
+			continue
+		}
+		// pinned hashes are only checked for the archive that would be used
+		path := fmt.Sprint(downloadFolder, "/", candidate.Record.FileName)
+		if err := CheckArchive(path, candidate.Step, &candidate.Record); err != nil {
+			fmt.Println(fmt.Sprint("Not using ", err))
+			continue
+		}
+		done[key] = true
+		used[candidate.Record.FileName] = true
+		matches = append(matches, candidate)
+	}
+
+	unmatched := []DownloadStep{}
+	for _, step := range config.DownloadSteps {
+		if !done[downloadStepKey(step)] {
+			unmatched = append(unmatched, step)
+		}
+	}
+	return kept, matches, unmatched
+}
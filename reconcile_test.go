@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseNexusFileName(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		version  string
+		ok       bool
+	}{
+		{"version", "Core-42-2-0-1650000000.7z", "2.0", true},
+		{"three part version", "Core-42-1-0-3-1650000000.zip", "1.0.3", true},
+		{"lettered version", "Core-42-2-0b-1650000000.7z", "2.0b", true},
+		{"no version", "Core-42-1650000000.7z", "", true},
+		{"mod id in the name", "Patch 42-42-1-1-1650000000.rar", "1.1", true},
+		{"duplicate download", "Core-42-2-0-1650000000 (1).7z", "", false},
+		{"other mod", "Core-43-2-0-1650000000.7z", "", false},
+		{"no timestamp", "Core-42-2-0.7z", "", false},
+		{"not from nexus", "core.7z", "", false},
+	}
+	for _, test := range tests {
+		version, ok := parseNexusFileName(test.fileName, 42)
+		if version != test.version || ok != test.ok {
+			t.Errorf("%s: version = %q, %v, want %q, %v", test.name, version, ok, test.version, test.ok)
+		}
+	}
+}
+
+func TestScoreArchive(t *testing.T) {
+	urlStep := DownloadStep{Type: URL, Url: "https://example.com/files/patch-1.2.zip", SiteFileName: "Patch"}
+	localStep := DownloadStep{Type: LOCAL, Path: "/home/user/mods/My Mod.7z", SiteFileName: "My Mod"}
+	githubStep := DownloadStep{Type: GITHUB_RELEASE, Repo: "test/mod", Asset: "mod-*.zip", SiteFileName: "Mod"}
+	coreStep := DownloadStep{Type: NEXUS, ModId: 42, SiteFileName: "Core"}
+	patchStep := DownloadStep{Type: NEXUS, ModId: 42, SiteFileName: "Core Patch for Tamriel Data"}
+	headsStep := DownloadStep{Type: NEXUS, ModId: 42, SiteFileName: "Better Heads Tomb"}
+	sizedStep := DownloadStep{Type: NEXUS, ModId: 42, SiteFileName: "Core", Size: 100}
+	coreArchive := []string{"Data Files/Core.esp", "Data Files/Meshes/core.nif", "readme.txt"}
+
+	tests := []struct {
+		name     string
+		step     DownloadStep
+		fileName string
+		folders  []string
+		score    int
+		opened   bool
+	}{
+		{"exact url name", urlStep, "patch-1.2.zip", nil, 14, false},
+		{"exact url name in other case", urlStep, "Patch-1.2.ZIP", nil, 14, false},
+		{"duplicate of exact name", urlStep, "patch-1.2 (1).zip", nil, 13, false},
+		{"exact local name", localStep, "My Mod.7z", nil, 14, false},
+		{"asset pattern", githubStep, "mod-1.0.zip", nil, 7, false},
+		{"version suffixed", coreStep, "Core-42-2-0-1650000000.7z", nil, 8, false},
+		{"version suffixed duplicate", coreStep, "Core-42-2-0-1650000000 (1).7z", nil, 7, false},
+		{"version suffixed with folders", coreStep, "Core-42-2-0-1650000000.7z", []string{"Data Files"}, 11, true},
+		{"missing folder", coreStep, "Core-42-2-0-1650000000.7z", []string{"Textures"}, 0, true},
+		{"similar name", headsStep, "Better_Heads-42-3-0-1650000000.7z", nil, 7, false},
+		{"ambiguous mod id only", patchStep, "Core-42-2-0-1650000000.7z", nil, 5, false},
+		{"ambiguous name prefix", coreStep, "Core Patch for Tamriel Data-42-1-1-1650000000.7z", nil, 8, false},
+		{"other mod with folders", coreStep, "Core-43-2-0-1650000000.7z", []string{"Data Files"}, 7, true},
+		{"other mod", coreStep, "Core-43-2-0-1650000000.7z", nil, 4, false},
+		{"pinned size", sizedStep, "Core-42-2-0-1650000000.7z", nil, 0, false},
+	}
+	for _, test := range tests {
+		opened := false
+		entries := func() ([]string, error) {
+			opened = true
+			return coreArchive, nil
+		}
+		score, reasons := scoreArchive(test.step, test.fileName, 200, test.folders, entries)
+		if score != test.score {
+			t.Errorf("%s: score = %d %v, want %d", test.name, score, reasons, test.score)
+		}
+		if opened != test.opened {
+			t.Errorf("%s: archive opened = %v, want %v", test.name, opened, test.opened)
+		}
+	}
+}
+
+func TestReconcileDownloadsPicksBestMatch(t *testing.T) {
+	folder := t.TempDir()
+	files := []string{
+		"Core-42-2-0-1650000000.7z",
+		"Core-42-2-0-1650000000 (1).7z",
+		"Core Patch for Tamriel Data-42-1-1-1650000000.7z",
+		"Unrelated-99-1-0-1650000000.7z",
+		"Music-42-1-0-1650000000.7z.part",
+	}
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(folder, file), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := ModListConfig{DownloadSteps: []DownloadStep{
+		{Type: NEXUS, ModId: 42, SiteFileName: "Core"},
+		{Type: NEXUS, ModId: 42, SiteFileName: "Core Patch for Tamriel Data"},
+		{Type: NEXUS, ModId: 42, SiteFileName: "Music"},
+	}}
+
+	kept, matches, unmatched := ReconcileDownloads(config, ManifestListConfig{}, folder)
+	if len(kept) != 0 {
+		t.Errorf("kept = %v, want none", kept)
+	}
+	matched := map[string]string{}
+	for _, match := range matches {
+		matched[match.Step.SiteFileName] = fmt.Sprint(match.Record.FileName, " ", match.Record.Version)
+	}
+	want := map[string]string{
+		"Core":                        "Core-42-2-0-1650000000.7z 2.0",
+		"Core Patch for Tamriel Data": "Core Patch for Tamriel Data-42-1-1-1650000000.7z 1.1",
+	}
+	if fmt.Sprint(matched) != fmt.Sprint(want) {
+		t.Errorf("matches = %v, want %v", matched, want)
+	}
+	if len(unmatched) != 1 || unmatched[0].SiteFileName != "Music" {
+		t.Errorf("unmatched = %v, want Music", unmatched)
+	}
+}
@@ -13,22 +13,45 @@ import (
 )
 
 type DownloadStep struct {
-	Type         string `yaml:"type"`
-	ModId        int32  `yaml:"modId"`
-	SiteFileName string `yaml:"siteFileName"`
+	Id            string `yaml:"id,omitempty"` // optional key for extending presets, defaults to modId/siteFileName
+	Type          string `yaml:"type"`
+	ModId         int32  `yaml:"modId"`
+	SiteFileName  string `yaml:"siteFileName"`
+	FileId        int64  `yaml:"fileId,omitempty"`        // nexus type: file id, matched instead of siteFileName
+	Version       string `yaml:"version,omitempty"`       // nexus type: version the file has to have
+	Url           string `yaml:"url,omitempty"`           // url type: address of the file
+	FileName      string `yaml:"fileName,omitempty"`      // url type: name to save the file as, when the url doesn't end in one
+	Repo          string `yaml:"repo,omitempty"`          // github-release type: owner/name of the repository
+	Tag           string `yaml:"tag,omitempty"`           // github-release type: release tag, the latest release when unset
+	Asset         string `yaml:"asset,omitempty"`         // github-release type: asset name, can be a glob like *.7z
+	Path          string `yaml:"path,omitempty"`          // local type: archive path, relative to the Aradir folder
+	Sha256        string `yaml:"sha256,omitempty"`        // expected sha256 of the archive
+	Size          int64  `yaml:"size,omitempty"`          // expected size of the archive in bytes
+	ExtractNested bool   `yaml:"extractNested,omitempty"` // also extract archives found inside the archive
+	If            string `yaml:"if,omitempty"`            // preset option that has to be on, or off with a leading !
+	Remove        bool   `yaml:"remove,omitempty"`        // removes the base preset step with the same key
+	InsertBefore  string `yaml:"insertBefore,omitempty"`  // key of the base preset step to insert in front of
 }
 
 type UnpackStep struct {
-	ModId     int32    `yaml:"modId"`
-	FileIndex int16    `yaml:"fileIndex"`
-	Type      string   `yaml:"type"`
-	Data      []string `yaml:"data"`
+	Id           string   `yaml:"id,omitempty"` // optional key for extending presets
+	ModId        int32    `yaml:"modId"`
+	FileIndex    int16    `yaml:"fileIndex"`
+	Type         string   `yaml:"type"`
+	Data         []string `yaml:"data"`
+	If           string   `yaml:"if,omitempty"`           // preset option that has to be on, or off with a leading !
+	Remove       bool     `yaml:"remove,omitempty"`       // removes the base preset step with the same id
+	InsertBefore string   `yaml:"insertBefore,omitempty"` // id of the base preset step to insert in front of
 }
 
 type ModListConfig struct {
 	Name          string         `yaml:"name"`
+	Extends       string         `yaml:"extends,omitempty"` // name of the base preset
 	LastModified  int32          `yaml:"lastModified"`
 	ListUrl       string         `yaml:"listUrl"`
+	Options       []PresetOption `yaml:"options,omitempty"`     // features users can switch on or off
+	SortContent   bool           `yaml:"sortContent,omitempty"` // move plugin masters in front of the plugins that need them
+	LoadOrder     LoadOrderRules `yaml:"loadOrder,omitempty"`   // before/after rules for mods and plugins
 	DownloadSteps []DownloadStep `yaml:"downloadSteps"`
 	UnpackSteps   []UnpackStep   `yaml:"unpackSteps"`
 }
@@ -37,6 +60,10 @@ type ManifestRecord struct {
 	FileName        string `yaml:"fileName"`
 	ModId           int32  `yaml:"modId"`
 	FileDisplayName string `yaml:"fileDisplayName"`
+	FileId          int64  `yaml:"fileId,omitempty"`  // nexus file id that was downloaded, when known
+	Version         string `yaml:"version,omitempty"` // version that was downloaded, when known
+	Sha256          string `yaml:"sha256,omitempty"`  // hash of the archive, recorded the first time it is checked
+	Size            int64  `yaml:"size,omitempty"`    // size of the archive in bytes, recorded with sha256
 }
 
 type ManifestListConfig struct {
@@ -45,16 +72,36 @@ type ManifestListConfig struct {
 	Records  []ManifestRecord `yaml:"records"`
 }
 
+// SetRecord replaces the record of the same download step, or adds it. A step has one record, since
+// unpacking uses the first one it finds.
+func (manifest *ManifestListConfig) SetRecord(record ManifestRecord) {
+	for i, existing := range manifest.Records {
+		if existing.ModId == record.ModId && existing.FileDisplayName == record.FileDisplayName {
+			manifest.Records[i] = record
+			return
+		}
+	}
+	manifest.Records = append(manifest.Records, record)
+}
+
 type PreferencesConfig struct {
-	Preset              string `yaml:"preset"`              // preset name
-	Downloads           string `yaml:"downloads"`           // downloads path
-	Modinstall          string `yaml:"modinstall"`          // mod extract/install path
-	Gamedata            string `yaml:"gamedata"`            // morrowind installation path
-	Settings            string `yaml:"settings"`            // openmw settings path
-	Openmw              string `yaml:"openmw"`              // openmw install path
-	Delta               string `yaml:"delta"`               // delta plugin executable path
-	Nodownload          bool   `yaml:"nodownload"`          // skip download step completely
-	SharedInstallFolder bool   `yaml:"sharedInstallFolder"` // use a combined install folder for all presets or one for each preset
+	Preset              string          `yaml:"preset"`              // preset name
+	Downloads           string          `yaml:"downloads"`           // downloads path
+	Modinstall          string          `yaml:"modinstall"`          // mod extract/install path
+	Gamedata            string          `yaml:"gamedata"`            // morrowind installation path
+	Settings            string          `yaml:"settings"`            // openmw settings path
+	Openmw              string          `yaml:"openmw"`              // openmw install path
+	Delta               string          `yaml:"delta"`               // delta plugin executable path
+	Nodownload          bool            `yaml:"nodownload"`          // skip download step completely
+	SharedInstallFolder bool            `yaml:"sharedInstallFolder"` // use a combined install folder for all presets or one for each preset
+	Encoding            string          `yaml:"encoding"`            // encoding of the game files, win1252 unless set
+	Options             map[string]bool `yaml:"options"`             // preset option choices, by option name
+	ParallelDownloads   int             `yaml:"parallelDownloads"`   // downloads running at once, 2 unless set
+	DownloadInterval    int             `yaml:"downloadInterval"`    // milliseconds between starting downloads from the same site, 500 unless set
+	ParallelExtractions int             `yaml:"parallelExtractions"` // archives extracted at once, 2 unless set
+	SelectiveExtract    bool            `yaml:"selectiveExtract"`    // only extract the paths the unpack steps use
+	NexusApiKey         string          `yaml:"nexusApiKey"`         // personal API key, downloads from Nexus without the browser
+	NexusApiUrl         string          `yaml:"nexusApiUrl"`         // Nexus API address, only changed for testing
 }
 
 // exists returns whether the given file or directory exists
@@ -81,7 +128,11 @@ func RemoveDuplicateStr(strSlice []string) []string {
 	return list
 }
 
-func GetDownloadedMods(listName string, downloadPath string) ([]string, ManifestListConfig) {
+// GetDownloadedMods lists the downloadStepKeys of steps whose archive is downloaded and matches,
+// by this preset or, through the download index, by any other. Names like "Main File" repeat
+// across mods, so steps are told apart by their key.
+// Archives that don't match can be moved aside and downloaded again.
+func GetDownloadedMods(listName string, downloadPath string, steps []DownloadStep) ([]string, ManifestListConfig) {
 	downloadedMods := []string{}
 	now := time.Now()
 	manifestTemplate := ManifestListConfig{
@@ -95,13 +146,41 @@ func GetDownloadedMods(listName string, downloadPath string) ([]string, Manifest
 
 	if listManifestExists {
 		manifest := ReadManifest(manifestName)
-		manifestTemplate = manifest
+		manifestTemplate.Created = manifest.Created
 		for _, val := range manifest.Records {
-			fileExists, err := Exists(fmt.Sprint(downloadPath, "/", val.FileName))
+			archivePath := fmt.Sprint(downloadPath, "/", val.FileName)
+			fileExists, err := Exists(archivePath)
 			checkError(err)
-			if fileExists {
-				downloadedMods = append(downloadedMods, val.FileDisplayName)
+			if !fileExists {
+				manifestTemplate.Records = append(manifestTemplate.Records, val)
+				continue
 			}
+
+			step, ok := findDownloadStep(steps, val)
+			if ok {
+				if err := CheckArchive(archivePath, step, &val); err != nil {
+					fmt.Println(err.Error())
+					if askYesNo(fmt.Sprint("Move ", val.FileName, " aside and download it again?")) {
+						checkError(os.Rename(archivePath, fmt.Sprint(archivePath, ".mismatch")))
+						continue
+					}
+				}
+				downloadedMods = append(downloadedMods, downloadStepKey(step))
+			}
+			manifestTemplate.Records = append(manifestTemplate.Records, val)
+		}
+	}
+
+	// archives downloaded for other presets count as downloaded too
+	index := ReadDownloadIndex()
+	for _, step := range steps {
+		if sliceContains(downloadedMods, downloadStepKey(step)) {
+			continue
+		}
+		if record, ok := findIndexedDownload(index, step, downloadPath); ok {
+			fmt.Println(fmt.Sprint("Using ", record.FileName, " for ", step.SiteFileName, ", it is already downloaded"))
+			manifestTemplate.SetRecord(record)
+			downloadedMods = append(downloadedMods, downloadStepKey(step))
 		}
 	}
 	return downloadedMods, manifestTemplate
@@ -124,6 +203,7 @@ func WriteManifest(manifestData *ManifestListConfig, configFileName string) {
 	if writeErr != nil {
 		log.Fatal(writeErr)
 	}
+	IndexManifest(manifestData)
 }
 
 func hasExts(path string, exts []string) bool {
@@ -153,22 +233,32 @@ func CreateFileList() []string {
 	return presets
 }
 
-func ReadPreset(fileName string) ModListConfig {
-	preset := ModListConfig{}
+func GetPresetPath(fileName string) string {
 	presetName := strings.Replace(fileName, ".yaml", "", 1)
-	file, err := ioutil.ReadFile(fmt.Sprint("./", "presets/", presetName, "/", fileName))
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-	}
+	return fmt.Sprint("./", "presets/", presetName, "/", presetName, ".yaml")
+}
 
-	parseErr := yaml.Unmarshal([]byte(file), &preset)
-	if parseErr != nil {
-		fmt.Println(parseErr.Error())
+// ReadPreset reads a preset and resolves any presets it extends
+func ReadPreset(fileName string) ModListConfig {
+	preset, err := ResolvePreset(fileName)
+	if err != nil {
 		log.Fatalf("error: %v", err)
 	}
 	return preset
 }
 
+// readPresetFile reads a single preset file without resolving extends
+func readPresetFile(fileName string) (ModListConfig, error) {
+	preset := ModListConfig{}
+	file, err := ioutil.ReadFile(GetPresetPath(fileName))
+	if err != nil {
+		return preset, err
+	}
+
+	err = yaml.Unmarshal([]byte(file), &preset)
+	return preset, err
+}
+
 func ReadPrefs(fileName string) PreferencesConfig {
 	preset := PreferencesConfig{}
 	file, err := ioutil.ReadFile(fmt.Sprint("./", fileName))
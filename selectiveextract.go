@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// With selectiveExtract set in preferences, only the folders and files the unpack steps use are
+// extracted from an archive. The marker of the folder lists them, and when another preset needs
+// more of the same archive, only the missing paths are extracted and moved in.
+
+// cleanArchivePath makes a preset path comparable with archive entries, "" is the whole archive
+func cleanArchivePath(path string) string {
+	path = strings.Trim(strings.ReplaceAll(strings.TrimSpace(path), "\\", "/"), "/")
+	if path == "." {
+		return ""
+	}
+	return strings.TrimPrefix(path, "./")
+}
+
+// unpackStepUses reports whether an unpack step reads from the archive of a record
+func unpackStepUses(downloads []DownloadStep, step UnpackStep, record ManifestRecord) bool {
+	if step.ModId != record.ModId {
+		return false
+	}
+	index := int16(0)
+	for _, download := range downloads {
+		if download.ModId != step.ModId {
+			continue
+		}
+		if index == step.FileIndex {
+			return download.SiteFileName == record.FileDisplayName
+		}
+		index++
+	}
+	return false
+}
+
+// ReferencedArchivePaths lists the paths the unpack steps use from the archive of a record.
+// It returns nil when the whole archive is needed, or no step says which parts.
+func ReferencedArchivePaths(config ModListConfig, record ManifestRecord) []string {
+	paths := []string{}
+	for _, step := range config.UnpackSteps {
+		if !unpackStepUses(config.DownloadSteps, step, record) {
+			continue
+		}
+		switch step.Type {
+		case DATA, RESOURCES, DEELETE_LIST:
+			paths = append(paths, step.Data...)
+		case INSTALL_TO_OMW:
+			// pairs of archive path and destination
+			for i := 0; i < len(step.Data); i += 2 {
+				paths = append(paths, step.Data[i])
+			}
+		case DELETE_LIST_BY_FILE:
+			for _, listPath := range step.Data {
+				lines, err := readLines(fmt.Sprint("./", listPath))
+				checkError(err)
+				paths = append(paths, lines...)
+			}
+		}
+	}
+
+	cleaned := []string{}
+	for _, path := range paths {
+		path = cleanArchivePath(path)
+		if path == "" {
+			return nil
+		}
+		cleaned = append(cleaned, path)
+	}
+	if len(cleaned) == 0 {
+		return nil
+	}
+	return RemoveDuplicateStr(cleaned)
+}
+
+// pathCovers reports whether path is parent, or the same as, another path, ignoring case
+func pathCovers(parent string, path string) bool {
+	parent = strings.ToLower(parent)
+	path = strings.ToLower(path)
+	return path == parent || strings.HasPrefix(path, parent+"/")
+}
+
+func pathsCover(parents []string, path string) bool {
+	for _, parent := range parents {
+		if pathCovers(parent, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// uncoveredPaths lists the paths that are not inside any of the extracted ones.
+// Extracted paths stand for the whole archive when there are none.
+func uncoveredPaths(extracted []string, paths []string) []string {
+	if len(extracted) == 0 {
+		return []string{}
+	}
+	uncovered := []string{}
+	for _, path := range paths {
+		if !pathsCover(extracted, path) {
+			uncovered = append(uncovered, path)
+		}
+	}
+	return uncovered
+}
+
+// archivePathFilter includes the entries inside paths, nil paths include everything
+func archivePathFilter(paths []string) func(name string) bool {
+	if paths == nil {
+		return nil
+	}
+	return func(name string) bool {
+		return pathsCover(paths, cleanArchivePath(name))
+	}
+}
+
+// resolvePathCase spells path the way the folders below root are spelled, since archives and presets
+// don't always agree on case. It also reports whether the whole path exists.
+func resolvePathCase(root string, path string) (string, bool) {
+	resolved := []string{}
+	parts := strings.Split(cleanArchivePath(path), "/")
+	for i, part := range parts {
+		entries, err := os.ReadDir(filepath.Join(append([]string{root}, resolved...)...))
+		if err != nil {
+			return strings.Join(append(resolved, parts[i:]...), "/"), false
+		}
+		match := ""
+		for _, entry := range entries {
+			if entry.Name() == part {
+				match = part
+				break
+			}
+			if match == "" && strings.EqualFold(entry.Name(), part) {
+				match = entry.Name()
+			}
+		}
+		if match == "" {
+			return strings.Join(append(resolved, parts[i:]...), "/"), false
+		}
+		resolved = append(resolved, match)
+	}
+	return strings.Join(resolved, "/"), true
+}
+
+// extractedPaths lists the paths that made it into a folder, spelled as they are on disk.
+// Paths missing from the archive are only reported, so they are extracted again next time.
+func extractedPaths(folder string, archiveName string, paths []string) ([]string, error) {
+	found := []string{}
+	for _, path := range paths {
+		resolved, exists := resolvePathCase(folder, path)
+		if !exists {
+			fmt.Println(fmt.Sprint("Warning: ", path, " is not in ", archiveName))
+			continue
+		}
+		found = append(found, resolved)
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("none of %s are in %s", strings.Join(paths, ", "), archiveName)
+	}
+	return found, nil
+}
+
+// mergeExtractedPaths moves the paths extracted into staging into an existing folder, and
+// returns the ones that were moved. Staging is removed either way, when a move fails the
+// paths moved before it are returned with the error.
+func mergeExtractedPaths(staging string, location string, archiveName string, paths []string) ([]string, error) {
+	found, err := extractedPaths(staging, archiveName, paths)
+	if err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	moved := []string{}
+	for _, path := range found {
+		if err := movePath(staging, location, path); err != nil {
+			os.RemoveAll(staging)
+			return moved, err
+		}
+		moved = append(moved, path)
+	}
+	return moved, os.RemoveAll(staging)
+}
+
+// movePath moves a path from staging into location, an existing folder of another case is replaced
+// instead of kept beside it
+func movePath(staging string, location string, path string) error {
+	destPath, _ := resolvePathCase(location, path)
+	dest := filepath.Join(location, destPath)
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(staging, path), filepath.Join(filepath.Dir(dest), filepath.Base(path)))
+}
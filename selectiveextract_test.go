@@ -0,0 +1,102 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestZip(t *testing.T, path string, names []string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	for _, name := range names {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte(name))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelectiveExtractResolvesCase(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "mod.zip")
+	writeTestZip(t, archivePath, []string{"Core/a.esp", "Optional/b.esp", "readme.txt"})
+	location := filepath.Join(dir, "mod")
+	record := ManifestRecord{FileName: "mod.zip", Sha256: "abc"}
+
+	// the preset spells the folders differently than the archive
+	job := ExtractJob{Record: record, ArchivePath: archivePath, Location: location, Paths: []string{"core", "missing"}}
+	if err := extractJob(job, nil); err != nil {
+		t.Fatal(err)
+	}
+	marker, complete := CompleteExtraction(location, record)
+	if !complete || !reflect.DeepEqual(marker.Paths, []string{"Core"}) {
+		t.Fatalf("marker = %v, complete = %v, want only Core", marker, complete)
+	}
+
+	job = ExtractJob{Record: record, ArchivePath: archivePath, Location: location, Paths: []string{"OPTIONAL"}, Extracted: marker.Paths}
+	if err := extractJob(job, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"Core/a.esp", "Optional/b.esp"} {
+		if _, err := os.Stat(filepath.Join(location, path)); err != nil {
+			t.Error(err)
+		}
+	}
+	marker, _ = ReadExtractMarker(location)
+	if !reflect.DeepEqual(marker.Paths, []string{"Core", "Optional"}) {
+		t.Errorf("marker paths = %v, want [Core Optional]", marker.Paths)
+	}
+	if missing := uncoveredPaths(marker.Paths, []string{"core", "optional", "missing"}); !reflect.DeepEqual(missing, []string{"missing"}) {
+		t.Errorf("missing = %v, want [missing]", missing)
+	}
+}
+
+func TestSelectiveExtractFailsWithoutPaths(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "mod.zip")
+	writeTestZip(t, archivePath, []string{"Core/a.esp"})
+	location := filepath.Join(dir, "mod")
+	job := ExtractJob{Record: ManifestRecord{FileName: "mod.zip", Sha256: "abc"}, ArchivePath: archivePath, Location: location, Paths: []string{"Textures"}}
+	if err := extractJob(job, nil); err == nil {
+		t.Fatal("extracting none of the paths succeeded")
+	}
+	if _, err := ReadExtractMarker(location); err == nil {
+		t.Error("a marker was written")
+	}
+}
+
+func TestSelectiveExtractCleansUpFailedMerge(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "mod.zip")
+	writeTestZip(t, archivePath, []string{"Core/a.esp", "Optional/b.esp", "Sub/Deep/c.esp"})
+	location := filepath.Join(dir, "mod")
+	record := ManifestRecord{FileName: "mod.zip", Sha256: "abc"}
+	if err := extractJob(ExtractJob{Record: record, ArchivePath: archivePath, Location: location, Paths: []string{"Core"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// a file where the folder of Sub/Deep has to go makes its move fail
+	os.WriteFile(filepath.Join(location, "Sub"), []byte("in the way"), 0644)
+
+	job := ExtractJob{Record: record, ArchivePath: archivePath, Location: location, Paths: []string{"Optional", "Sub/Deep"}, Extracted: []string{"Core"}}
+	if err := extractJob(job, nil); err == nil {
+		t.Fatal("the merge succeeded")
+	}
+	if _, err := os.Stat(location + PARTIAL_EXT); !os.IsNotExist(err) {
+		t.Error("the staging folder is left behind")
+	}
+	marker, _ := ReadExtractMarker(location)
+	if !reflect.DeepEqual(marker.Paths, []string{"Core", "Optional"}) {
+		t.Errorf("marker paths = %v, want the moved Optional added", marker.Paths)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// SettingsEntry is one line of settings.cfg. Section headers have a section and no key,
+// comments and blank lines only keep their raw text.
+type SettingsEntry struct {
+	Section string
+	Key     string
+	Value   string
+	Raw     string
+	Header  bool
+}
+
+func (entry SettingsEntry) IsHeader() bool {
+	return entry.Header
+}
+
+func (entry SettingsEntry) IsSetting() bool {
+	return entry.Key != ""
+}
+
+func (entry SettingsEntry) String() string {
+	if entry.IsSetting() {
+		return fmt.Sprint(entry.Key, " = ", entry.Value)
+	}
+	if entry.IsHeader() {
+		return fmt.Sprint("[", entry.Section, "]")
+	}
+	return entry.Raw
+}
+
+// SettingValue is a single section/key/value set by a SETTINGS step
+type SettingValue struct {
+	Section string
+	Key     string
+	Value   string
+}
+
+func (setting SettingValue) Id() string {
+	return fmt.Sprint("[", setting.Section, "] ", setting.Key)
+}
+
+// SettingsConfig keeps settings.cfg in order, with every setting tied to its section
+type SettingsConfig struct {
+	Entries []SettingsEntry
+}
+
+func parseSectionHeader(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+		return strings.TrimSpace(trimmed[1 : len(trimmed)-1]), true
+	}
+	return "", false
+}
+
+func parseSettingLine(line string) (string, string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || !strings.Contains(trimmed, "=") {
+		return "", "", false
+	}
+	parts := strings.SplitN(trimmed, "=", 2)
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), true
+}
+
+func ParseSettingsConfig(reader io.Reader) (*SettingsConfig, error) {
+	settings := &SettingsConfig{Entries: []SettingsEntry{}}
+	section := ""
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header, ok := parseSectionHeader(line); ok {
+			section = header
+			settings.Entries = append(settings.Entries, SettingsEntry{Section: section, Header: true})
+		} else if key, value, ok := parseSettingLine(line); ok {
+			settings.Entries = append(settings.Entries, SettingsEntry{Section: section, Key: key, Value: value})
+		} else {
+			settings.Entries = append(settings.Entries, SettingsEntry{Section: section, Raw: line})
+		}
+	}
+	return settings, scanner.Err()
+}
+
+// ReadSettingsConfig reads settings.cfg, a missing file is an empty config
+func ReadSettingsConfig(path string) (*SettingsConfig, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &SettingsConfig{Entries: []SettingsEntry{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseSettingsConfig(file)
+}
+
+func (settings *SettingsConfig) Lines() []string {
+	lines := []string{}
+	for _, entry := range settings.Entries {
+		lines = append(lines, entry.String())
+	}
+	return lines
+}
+
+func (settings *SettingsConfig) Write(path string) error {
+	return writeLines(settings.Lines(), path)
+}
+
+func (settings *SettingsConfig) Get(section string, key string) (string, bool) {
+	for _, entry := range settings.Entries {
+		if entry.IsSetting() && entry.Section == section && entry.Key == key {
+			return entry.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set overwrites a key in place, or adds it to the end of its section.
+// Missing sections are added to the end of the file.
+func (settings *SettingsConfig) Set(section string, key string, value string) {
+	lastInSection := -1
+	for i, entry := range settings.Entries {
+		if entry.Section != section {
+			continue
+		}
+		if entry.IsSetting() && entry.Key == key {
+			settings.Entries[i].Value = value
+			return
+		}
+		if entry.IsHeader() || entry.IsSetting() {
+			lastInSection = i
+		}
+	}
+
+	newEntry := SettingsEntry{Section: section, Key: key, Value: value}
+	if lastInSection < 0 {
+		if len(settings.Entries) > 0 && strings.TrimSpace(settings.Entries[len(settings.Entries)-1].String()) != "" {
+			settings.Entries = append(settings.Entries, SettingsEntry{Section: settings.Entries[len(settings.Entries)-1].Section})
+		}
+		settings.Entries = append(settings.Entries, SettingsEntry{Section: section, Header: true}, newEntry)
+		return
+	}
+
+	index := lastInSection + 1
+	settings.Entries = append(settings.Entries[:index], append([]SettingsEntry{newEntry}, settings.Entries[index:]...)...)
+}
+
+// ParseSettingsStep reads the data of a SETTINGS step, where every key needs a [Section] before it
+func ParseSettingsStep(data []string) ([]SettingValue, error) {
+	values := []SettingValue{}
+	section := ""
+	for _, line := range data {
+		if header, ok := parseSectionHeader(line); ok {
+			section = header
+		} else if key, value, ok := parseSettingLine(line); ok {
+			if section == "" {
+				return values, fmt.Errorf("setting %q has no [Section] before it", key)
+			}
+			values = append(values, SettingValue{Section: section, Key: key, Value: value})
+		} else if strings.TrimSpace(line) != "" {
+			return values, fmt.Errorf("%q is neither a [Section] nor a key = value pair", line)
+		}
+	}
+	return values, nil
+}
+
+// conditionsExclusive reports whether two `if:` conditions can never both be on
+func conditionsExclusive(a string, b string) bool {
+	nameA, negatedA := parseCondition(a)
+	nameB, negatedB := parseCondition(b)
+	return nameA != "" && nameA == nameB && negatedA != negatedB
+}
+
+// SettingsConflict is two SETTINGS steps giving the same key different values
+type SettingsConflict struct {
+	Setting   SettingValue
+	Previous  string
+	StepIndex int
+}
+
+func (conflict SettingsConflict) String() string {
+	return fmt.Sprintf("%s is set to %q, but an earlier step set it to %q", conflict.Setting.Id(), conflict.Setting.Value, conflict.Previous)
+}
+
+// FindSettingsConflicts checks the SETTINGS steps of a preset against each other
+func FindSettingsConflicts(steps []UnpackStep) []SettingsConflict {
+	type assignment struct {
+		value     string
+		condition string
+	}
+	conflicts := []SettingsConflict{}
+	assigned := make(map[string][]assignment)
+	for i, step := range steps {
+		if step.Type != SETTINGS {
+			continue
+		}
+		values, _ := ParseSettingsStep(step.Data)
+		for _, setting := range values {
+			for _, earlier := range assigned[setting.Id()] {
+				if earlier.value != setting.Value && !conditionsExclusive(earlier.condition, step.If) {
+					conflicts = append(conflicts, SettingsConflict{Setting: setting, Previous: earlier.value, StepIndex: i})
+					break
+				}
+			}
+			assigned[setting.Id()] = append(assigned[setting.Id()], assignment{value: setting.Value, condition: step.If})
+		}
+	}
+	return conflicts
+}
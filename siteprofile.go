@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// Site profiles describe how to start a download on a mod site, so selector fixes can ship
+// without a new build. Actions run in order, each looks for an element on the page, or inside
+// the element of its parent action, and runs its `then` actions inside what it found.
+// {siteFileName}, {fileId} and {version} in text are replaced with the values of the step.
+// Attributes captured along the way, like the version of the file, are recorded in the manifest.
+
+const SITE_PROFILE_VERSION = 2
+
+type SiteAction struct {
+	Name      string            `yaml:"name"`
+	If        string            `yaml:"if,omitempty"` // step value that has to be set, or unset with a leading !
+	Selector  string            `yaml:"selector"`
+	Text      string            `yaml:"text,omitempty"`      // js regex the element text has to match, ie "/Manual download/i"
+	Contains  string            `yaml:"contains,omitempty"`  // text the element has to contain
+	Attribute string            `yaml:"attribute,omitempty"` // attribute compared by equals and named, instead of the text
+	Equals    string            `yaml:"equals,omitempty"`    // the attribute, or the trimmed text, has to be exactly this
+	Named     string            `yaml:"named,omitempty"`     // like equals, or else the only element containing it
+	Missing   string            `yaml:"missing,omitempty"`   // error message when nothing is found
+	Checks    []SiteCheck       `yaml:"checks,omitempty"`    // run on the element that was found
+	Next      bool              `yaml:"next,omitempty"`      // continue with the next sibling of the element
+	Parent    bool              `yaml:"parent,omitempty"`    // continue with the parent of the element
+	Click     bool              `yaml:"click,omitempty"`
+	Optional  bool              `yaml:"optional,omitempty"` // only waits briefly, and is skipped when not found
+	Capture   map[string]string `yaml:"capture,omitempty"`  // value name to attribute, read from the element found
+	Then      []SiteAction      `yaml:"then,omitempty"`
+}
+
+// SiteCheck reports an element that is inside closest, or whose attribute isn't equals
+type SiteCheck struct {
+	If        string `yaml:"if,omitempty"`
+	Closest   string `yaml:"closest,omitempty"`
+	Attribute string `yaml:"attribute,omitempty"`
+	Equals    string `yaml:"equals,omitempty"`
+	Message   string `yaml:"message"`
+	Fatal     bool   `yaml:"fatal,omitempty"` // stops the download instead of printing a warning
+}
+
+// SiteFileList describes the files listed on the files url, each element matching
+// selector is a file, with its id, name and version in attributes
+type SiteFileList struct {
+	Selector string `yaml:"selector"`
+	Id       string `yaml:"id"`
+	Name     string `yaml:"name"`
+	Version  string `yaml:"version"`
+	Old      string `yaml:"old,omitempty"` // files inside this selector are no longer current
+}
+
+type SiteProfile struct {
+	Version  int          `yaml:"version"`
+	Name     string       `yaml:"name"`
+	FilesUrl string       `yaml:"filesUrl"` // page the actions start on
+	FileList SiteFileList `yaml:"fileList,omitempty"`
+	Actions  []SiteAction `yaml:"actions"`
+}
+
+func GetSiteProfilePath(name string) string {
+	return fmt.Sprint("./", "sites/", name, ".yaml")
+}
+
+func ReadSiteProfile(name string) (SiteProfile, error) {
+	profile := SiteProfile{}
+	file, err := ioutil.ReadFile(GetSiteProfilePath(name))
+	if err != nil {
+		return profile, err
+	}
+	if err := yaml.Unmarshal(file, &profile); err != nil {
+		return profile, fmt.Errorf("site profile %s: %v", name, err)
+	}
+	if err := profile.Check(); err != nil {
+		return profile, fmt.Errorf("site profile %s: %v", name, err)
+	}
+	return profile, nil
+}
+
+// Check reports profiles made for a newer Aradir, and actions that can't run
+func (profile SiteProfile) Check() error {
+	if profile.Version > SITE_PROFILE_VERSION {
+		return fmt.Errorf("version %d needs a newer Aradir, this one reads up to version %d", profile.Version, SITE_PROFILE_VERSION)
+	}
+	if !strings.Contains(profile.FilesUrl, "{modId}") {
+		return fmt.Errorf("filesUrl has no {modId}")
+	}
+	if list := profile.FileList; list.Selector != "" && (list.Id == "" || list.Name == "") {
+		return fmt.Errorf("fileList needs an id and a name attribute")
+	}
+	return checkSiteActions(profile.Actions)
+}
+
+func checkSiteActions(actions []SiteAction) error {
+	for _, action := range actions {
+		if action.Selector == "" {
+			return fmt.Errorf("action %q has no selector", action.Name)
+		}
+		matches := 0
+		for _, match := range []string{action.Text, action.Contains, action.Equals, action.Named} {
+			if match != "" {
+				matches++
+			}
+		}
+		if matches > 1 {
+			return fmt.Errorf("action %q can only use one of text, contains, equals and named", action.Name)
+		}
+		if action.Next && action.Parent {
+			return fmt.Errorf("action %q can move to next or parent, not both", action.Name)
+		}
+		for _, check := range action.Checks {
+			if (check.Closest == "") == (check.Attribute == "") || check.Message == "" {
+				return fmt.Errorf("checks of action %q need a message and either closest or attribute", action.Name)
+			}
+		}
+		if err := checkSiteActions(action.Then); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SiteValues are the values of a download step that profiles can use
+func SiteValues(step DownloadStep) map[string]string {
+	values := map[string]string{"siteFileName": step.SiteFileName, "version": step.Version, "fileId": ""}
+	if step.FileId > 0 {
+		values["fileId"] = fmt.Sprint(step.FileId)
+	}
+	return values
+}
+
+func expandSiteValues(text string, values map[string]string) string {
+	for key, value := range values {
+		text = strings.ReplaceAll(text, fmt.Sprint("{", key, "}"), value)
+	}
+	return text
+}
+
+// siteConditionHolds checks an `if:` against the step values, an empty condition always holds
+func siteConditionHolds(condition string, values map[string]string) bool {
+	if condition == "" {
+		return true
+	}
+	name, negated := parseCondition(condition)
+	return (values[name] != "") != negated
+}
+
+// ModFilesUrl is the page the actions start on for a mod
+func (profile SiteProfile) ModFilesUrl(modId int32) string {
+	return strings.ReplaceAll(profile.FilesUrl, "{modId}", fmt.Sprint(modId))
+}
+
+// siteSearch is either the whole page or an element found by an earlier action
+type siteSearch interface {
+	Element(selector string) (*rod.Element, error)
+	ElementR(selector string, jsRegex string) (*rod.Element, error)
+	ElementByJS(opts *rod.EvalOptions) (*rod.Element, error)
+}
+
+// elementContaining finds the first element matching selector whose text contains text
+func elementContaining(search siteSearch, selector string, text string) (*rod.Element, error) {
+	js := fmt.Sprint(`(selector, text) => Array.from(`, searchRoot(search), `.querySelectorAll(selector)).find(el => el.textContent.includes(text))`)
+	return search.ElementByJS(rod.Eval(js, selector, text))
+}
+
+// elementEquals finds the first element whose attribute, or trimmed text, is exactly value.
+// When fallback is set and nothing is equal, the only element containing value is used.
+func elementEquals(search siteSearch, selector string, attribute string, value string, fallback bool) (*rod.Element, error) {
+	js := fmt.Sprint(`(selector, attribute, value, fallback) => {
+		const elements = Array.from(`, searchRoot(search), `.querySelectorAll(selector));
+		const valueOf = el => attribute ? (el.getAttribute(attribute) || "") : el.textContent.trim();
+		const equal = elements.find(el => valueOf(el) === value);
+		if (equal || !fallback) {
+			return equal;
+		}
+		const containing = elements.filter(el => valueOf(el).includes(value));
+		return containing.length === 1 ? containing[0] : undefined;
+	}`)
+	return search.ElementByJS(rod.Eval(js, selector, attribute, value, fallback))
+}
+
+func searchRoot(search siteSearch) string {
+	if _, ok := search.(*rod.Element); ok {
+		return "this"
+	}
+	return "document"
+}
+
+// runSiteChecks prints the warnings of an element, and returns the first fatal one as an error
+func runSiteChecks(element *rod.Element, checks []SiteCheck, values map[string]string) error {
+	for _, check := range checks {
+		if !siteConditionHolds(check.If, values) {
+			continue
+		}
+		failed := false
+		if check.Closest != "" {
+			result, err := element.Eval(`(selector) => this.closest(selector) !== null`, check.Closest)
+			if err != nil {
+				return err
+			}
+			failed = result.Value.Bool()
+		} else {
+			attribute, err := element.Attribute(check.Attribute)
+			if err != nil {
+				return err
+			}
+			failed = attribute == nil || *attribute != expandSiteValues(check.Equals, values)
+		}
+		if !failed {
+			continue
+		}
+		message := expandSiteValues(check.Message, values)
+		if check.Fatal {
+			return fmt.Errorf("%s", message)
+		}
+		fmt.Println(fmt.Sprint("Warning: ", message))
+	}
+	return nil
+}
+
+// searchWithin makes a search give up when ctx ends
+func searchWithin(search siteSearch, ctx context.Context) siteSearch {
+	switch searched := search.(type) {
+	case *rod.Page:
+		return searched.Context(ctx)
+	case *rod.Element:
+		return searched.Context(ctx)
+	}
+	return search
+}
+
+// captureAttributes reads the captured attributes of an element into captured
+func captureAttributes(element *rod.Element, capture map[string]string, captured map[string]string) error {
+	for name, attribute := range capture {
+		value, err := element.Attribute(attribute)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			captured[name] = *value
+		}
+	}
+	return nil
+}
+
+// runSiteAction finds the element of one action and runs its checks, captures and click. Finding
+// an optional element has NEXUS_POPUP_TIMEOUT, everything else NEXUS_STAGE_TIMEOUT.
+// A nil element without an error is a missing optional one.
+func runSiteAction(ctx context.Context, search siteSearch, action SiteAction, values map[string]string, captured map[string]string) (*rod.Element, error) {
+	findTimeout := NEXUS_STAGE_TIMEOUT
+	if action.Optional {
+		findTimeout = NEXUS_POPUP_TIMEOUT
+	}
+	findCtx, cancelFind := context.WithTimeout(ctx, findTimeout)
+	defer cancelFind()
+	actionSearch := searchWithin(search, findCtx)
+
+	var element *rod.Element
+	var err error
+	switch {
+	case action.Contains != "":
+		element, err = elementContaining(actionSearch, action.Selector, expandSiteValues(action.Contains, values))
+	case action.Equals != "":
+		element, err = elementEquals(actionSearch, action.Selector, action.Attribute, expandSiteValues(action.Equals, values), false)
+	case action.Named != "":
+		element, err = elementEquals(actionSearch, action.Selector, action.Attribute, expandSiteValues(action.Named, values), true)
+	case action.Text != "":
+		element, err = actionSearch.ElementR(action.Selector, expandSiteValues(action.Text, values))
+	default:
+		element, err = actionSearch.Element(action.Selector)
+	}
+	if err != nil && action.Optional {
+		return nil, nil
+	}
+	if err != nil && action.Missing != "" {
+		return nil, fmt.Errorf("%s", expandSiteValues(action.Missing, values))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", siteActionName(action), err)
+	}
+
+	actCtx, cancelAct := context.WithTimeout(ctx, NEXUS_STAGE_TIMEOUT)
+	defer cancelAct()
+	element = element.Context(actCtx)
+	err = runSiteChecks(element, action.Checks, values)
+	if err == nil {
+		err = captureAttributes(element, action.Capture, captured)
+	}
+	if err == nil && action.Next {
+		element, err = element.Next()
+	} else if err == nil && action.Parent {
+		element, err = element.Parent()
+	}
+	if err == nil && action.Click {
+		err = element.Click(proto.InputMouseButtonLeft)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", siteActionName(action), err)
+	}
+	return element, nil
+}
+
+func siteActionName(action SiteAction) string {
+	if action.Name != "" {
+		return action.Name
+	}
+	return action.Selector
+}
+
+// runSiteActions runs actions against the page or an element, each with its own timeout
+func runSiteActions(ctx context.Context, search siteSearch, actions []SiteAction, values map[string]string, captured map[string]string) error {
+	for _, action := range actions {
+		if !siteConditionHolds(action.If, values) {
+			continue
+		}
+		element, err := runSiteAction(ctx, search, action, values, captured)
+		if err != nil {
+			return err
+		}
+		if element == nil {
+			continue
+		}
+		if err := runSiteActions(ctx, element, action.Then, values, captured); err != nil {
+			return fmt.Errorf("%s: %w", siteActionName(action), err)
+		}
+	}
+	return nil
+}
+
+// RunSiteProfile starts the download of a step's file on a page that has the profile's files url open,
+// and returns the values captured on the way
+func RunSiteProfile(page *rod.Page, profile SiteProfile, step DownloadStep) (map[string]string, error) {
+	captured := make(map[string]string)
+	err := runSiteActions(page.GetContext(), page, profile.Actions, SiteValues(step), captured)
+	return captured, err
+}
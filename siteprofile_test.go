@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+func TestSiteProfileCheck(t *testing.T) {
+	profile, err := ReadSiteProfile(NEXUS)
+	if err != nil {
+		t.Fatalf("sites/nexus.yaml: %v", err)
+	}
+
+	click := SiteAction{Name: "click", Selector: "a", Click: true}
+	tests := []struct {
+		name    string
+		change  func(profile *SiteProfile)
+		problem string
+	}{
+		{"newer version", func(profile *SiteProfile) { profile.Version = SITE_PROFILE_VERSION + 1 }, "needs a newer Aradir"},
+		{"no mod id", func(profile *SiteProfile) { profile.FilesUrl = "https://example.com/files" }, "no {modId}"},
+		{"file list without name", func(profile *SiteProfile) { profile.FileList.Name = "" }, "fileList needs"},
+		{"no selector", func(profile *SiteProfile) {
+			profile.Actions = []SiteAction{{Name: "empty"}}
+		}, "has no selector"},
+		{"two matches", func(profile *SiteProfile) {
+			profile.Actions = []SiteAction{{Name: "both", Selector: "a", Text: "/a/", Equals: "a"}}
+		}, "only use one of"},
+		{"next and parent", func(profile *SiteProfile) {
+			profile.Actions = []SiteAction{{Name: "move", Selector: "a", Next: true, Parent: true}}
+		}, "not both"},
+		{"check without message", func(profile *SiteProfile) {
+			profile.Actions = []SiteAction{{Name: "checked", Selector: "a", Checks: []SiteCheck{{Closest: "div"}}}}
+		}, "need a message"},
+		{"check with closest and attribute", func(profile *SiteProfile) {
+			profile.Actions = []SiteAction{{Name: "checked", Selector: "a", Checks: []SiteCheck{{Closest: "div", Attribute: "id", Message: "m"}}}}
+		}, "need a message"},
+		{"nested", func(profile *SiteProfile) {
+			profile.Actions = []SiteAction{{Name: "outer", Selector: "div", Then: []SiteAction{click, {Name: "inner"}}}}
+		}, `"inner" has no selector`},
+	}
+	for _, test := range tests {
+		changed := profile
+		test.change(&changed)
+		err := changed.Check()
+		if err == nil || !strings.Contains(err.Error(), test.problem) {
+			t.Errorf("%s: error = %v, want %q", test.name, err, test.problem)
+		}
+	}
+}
+
+func TestSiteConditionHolds(t *testing.T) {
+	values := SiteValues(DownloadStep{SiteFileName: "Core", FileId: 1001})
+	tests := []struct {
+		condition string
+		holds     bool
+	}{
+		{"", true},
+		{"fileId", true},
+		{"!fileId", false},
+		{"version", false},
+		{"!version", true},
+		{" ! version ", true},
+		{"unknown", false},
+	}
+	for _, test := range tests {
+		if holds := siteConditionHolds(test.condition, values); holds != test.holds {
+			t.Errorf("%q holds = %v, want %v", test.condition, holds, test.holds)
+		}
+	}
+}
+
+// testFilesPage opens the saved files tab of testdata in a browser, the test is skipped without one.
+// The returned profile is sites/nexus.yaml with its files url pointing at the saved page.
+func testFilesPage(t *testing.T) (*rod.Page, SiteProfile) {
+	path, found := launcher.LookPath()
+	if !found {
+		t.Skip("no browser to run the site profile in")
+	}
+	browserLauncher := launcher.New().Bin(path).Headless(true).NoSandbox(true)
+	controlUrl, err := browserLauncher.Launch()
+	if err != nil {
+		t.Skip("the browser doesn't start: ", err)
+	}
+	t.Cleanup(browserLauncher.Cleanup)
+	browser := rod.New().ControlURL(controlUrl)
+	if err := browser.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { browser.Close() })
+
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	t.Cleanup(server.Close)
+
+	profile, err := ReadSiteProfile(NEXUS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.FilesUrl = server.URL + "/nexus-files.html?mod={modId}"
+	page, err := browser.Page(proto.TargetCreateTarget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page, profile
+}
+
+func TestRunSiteProfile(t *testing.T) {
+	page, profile := testFilesPage(t)
+
+	tests := []struct {
+		name    string
+		step    DownloadStep
+		clicked string // location hash after the download link was clicked
+		version string
+		problem string
+	}{
+		{"by id", DownloadStep{SiteFileName: "Core", FileId: 1001}, "#download-1001", "2.0", ""},
+		{"by name", DownloadStep{SiteFileName: "Core"}, "#download-1001", "2.0", ""},
+		{"by part of the name", DownloadStep{SiteFileName: "Tamriel Data"}, "#download-1002", "1.1", ""},
+		{"old file", DownloadStep{SiteFileName: "Core", FileId: 900}, "#download-900", "1.0", ""},
+		{"pinned version", DownloadStep{SiteFileName: "Core", FileId: 1001, Version: "2.0"}, "#download-1001", "2.0", ""},
+		{"changed version", DownloadStep{SiteFileName: "Core", FileId: 1001, Version: "1.9"}, "", "", "is not version 1.9"},
+		{"removed file", DownloadStep{SiteFileName: "Core", FileId: 800}, "", "", "file 800 (Core) is no longer on the files tab"},
+		{"unknown name", DownloadStep{SiteFileName: "Textures"}, "", "", "no file named Textures"},
+	}
+	for i, test := range tests {
+		// a new url every time, so the page loads again
+		if err := nextPage(page, profile.ModFilesUrl(int32(i))); err != nil {
+			t.Fatal(err)
+		}
+		captured, err := RunSiteProfile(page, profile, test.step)
+		if test.problem != "" {
+			if err == nil || !strings.Contains(err.Error(), test.problem) {
+				t.Errorf("%s: error = %v, want %q", test.name, err, test.problem)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if captured["version"] != test.version {
+			t.Errorf("%s: captured version %q, want %q", test.name, captured["version"], test.version)
+		}
+		hash, err := page.Eval(`() => location.hash`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash.Value.Str() != test.clicked {
+			t.Errorf("%s: clicked %q, want %q", test.name, hash.Value.Str(), test.clicked)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	cp "github.com/otiai10/copy"
+)
+
+// Download step types. Nexus downloads go through the browser, the others are fetched directly.
+const NEXUS = "nexus"
+const URL = "url"
+const GITHUB_RELEASE = "github-release"
+const LOCAL = "local"
+
+var GITHUB_API_URL = "https://api.github.com"
+
+const PARTIAL_EXT = ".partial"
+
+// Downloader fetches files over plain http, resuming from a .partial file when a download was cut off
+type Downloader struct {
+	Client     *http.Client
+	UserAgent  string
+	OnProgress func(received int64, total int64) // optional, total is 0 when the server doesn't say
+}
+
+// progressReader counts the bytes read through it
+type progressReader struct {
+	reader     io.Reader
+	received   int64
+	total      int64
+	onProgress func(received int64, total int64)
+}
+
+func (reader *progressReader) Read(data []byte) (int, error) {
+	count, err := reader.reader.Read(data)
+	reader.received += int64(count)
+	if reader.onProgress != nil {
+		reader.onProgress(reader.received, reader.total)
+	}
+	return count, err
+}
+
+func NewDownloader() Downloader {
+	return Downloader{Client: &http.Client{}, UserAgent: "mw-aradir"}
+}
+
+func (downloader Downloader) get(rawUrl string, offset int64) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", downloader.UserAgent)
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprint("bytes=", offset, "-"))
+	}
+	return downloader.Client.Do(request)
+}
+
+// Download saves a url to dest. Bytes already in dest.partial are kept when the server supports ranges.
+func (downloader Downloader) Download(rawUrl string, dest string) error {
+	partialPath := fmt.Sprint(dest, PARTIAL_EXT)
+	offset := int64(0)
+	if info, err := os.Stat(partialPath); err == nil {
+		offset = info.Size()
+	}
+
+	response, err := downloader.get(rawUrl, offset)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case response.StatusCode == http.StatusPartialContent && offset > 0:
+		flags |= os.O_APPEND
+	case response.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// the partial file already holds everything
+		return os.Rename(partialPath, dest)
+	case response.StatusCode == http.StatusOK:
+		flags |= os.O_TRUNC
+	default:
+		return fmt.Errorf("downloading %s: %s", rawUrl, response.Status)
+	}
+
+	file, err := os.OpenFile(partialPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	if flags&os.O_TRUNC != 0 {
+		offset = 0
+	}
+	total := int64(0)
+	if response.ContentLength > 0 {
+		total = offset + response.ContentLength
+	}
+	body := &progressReader{reader: response.Body, received: offset, total: total, onProgress: downloader.OnProgress}
+	_, copyErr := io.Copy(file, body)
+	closeErr := file.Close()
+	if copyErr != nil {
+		return fmt.Errorf("downloading %s: %v", rawUrl, copyErr)
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(partialPath, dest)
+}
+
+type githubAsset struct {
+	Name               string `json:"name"`
+	BrowserDownloadUrl string `json:"browser_download_url"`
+}
+
+type githubRelease struct {
+	TagName string        `json:"tag_name"`
+	Assets  []githubAsset `json:"assets"`
+}
+
+// FindGithubRelease reads the release of a step, the latest release when no tag is set
+func (downloader Downloader) FindGithubRelease(step DownloadStep) (githubRelease, error) {
+	releaseUrl := fmt.Sprint(GITHUB_API_URL, "/repos/", step.Repo, "/releases/latest")
+	if step.Tag != "" {
+		releaseUrl = fmt.Sprint(GITHUB_API_URL, "/repos/", step.Repo, "/releases/tags/", url.PathEscape(step.Tag))
+	}
+
+	release := githubRelease{}
+	response, err := downloader.get(releaseUrl, 0)
+	if err != nil {
+		return release, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return release, fmt.Errorf("reading release of %s: %s", step.Repo, response.Status)
+	}
+	if err := json.NewDecoder(response.Body).Decode(&release); err != nil {
+		return release, fmt.Errorf("reading release of %s: %v", step.Repo, err)
+	}
+	return release, nil
+}
+
+// FindGithubAsset looks up the release asset of a step
+func (downloader Downloader) FindGithubAsset(step DownloadStep) (githubRelease, githubAsset, error) {
+	release, err := downloader.FindGithubRelease(step)
+	if err != nil {
+		return release, githubAsset{}, err
+	}
+	for _, asset := range release.Assets {
+		if matched, _ := filepath.Match(step.Asset, asset.Name); matched {
+			return release, asset, nil
+		}
+	}
+	return release, githubAsset{}, fmt.Errorf("release %s of %s has no asset matching %q", release.TagName, step.Repo, step.Asset)
+}
+
+// urlFileName is the file name at the end of a url path
+func urlFileName(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	name, _ := url.PathUnescape(path.Base(parsed.Path))
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
+
+// DownloadedFile is what a finished download records in the manifest
+type DownloadedFile struct {
+	FileName string
+	FileId   int64  // nexus file id, when known
+	Version  string // file version, or release tag for github releases
+}
+
+// DownloadFromSource fetches a url, github-release or local step into the download folder
+func (downloader Downloader) DownloadFromSource(step DownloadStep, downloadFolder string) (DownloadedFile, error) {
+	switch step.Type {
+	case URL:
+		fileName := step.FileName
+		if fileName == "" {
+			fileName = urlFileName(step.Url)
+		}
+		if fileName == "" {
+			return DownloadedFile{}, fmt.Errorf("no file name in %s, set fileName on the step", step.Url)
+		}
+		return DownloadedFile{FileName: fileName}, downloader.Download(step.Url, fmt.Sprint(downloadFolder, "/", fileName))
+	case GITHUB_RELEASE:
+		release, asset, err := downloader.FindGithubAsset(step)
+		if err != nil {
+			return DownloadedFile{}, err
+		}
+		file := DownloadedFile{FileName: asset.Name, Version: release.TagName}
+		return file, downloader.Download(asset.BrowserDownloadUrl, fmt.Sprint(downloadFolder, "/", asset.Name))
+	case LOCAL:
+		fileName := filepath.Base(step.Path)
+		return DownloadedFile{FileName: fileName}, cp.Copy(getLocalSourcePath(step.Path), fmt.Sprint(downloadFolder, "/", fileName))
+	}
+	return DownloadedFile{}, fmt.Errorf("%q is not a direct download type", step.Type)
+}
+
+// getLocalSourcePath resolves local sources relative to the Aradir folder
+func getLocalSourcePath(sourcePath string) string {
+	if filepath.IsAbs(sourcePath) {
+		return sourcePath
+	}
+	return fmt.Sprint("./", strings.TrimPrefix(sourcePath, "./"))
+}
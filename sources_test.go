@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testArchive = bytes.Repeat([]byte("0123456789"), 1000)
+
+// testFileServer serves testArchive with range support, and records the Range header of every request
+func testFileServer(t *testing.T, ranges bool) (*httptest.Server, *[]string) {
+	requested := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requested = append(requested, request.Header.Get("Range"))
+		if !ranges {
+			writer.Write(testArchive)
+			return
+		}
+		http.ServeContent(writer, request, "mod.zip", time.Time{}, bytes.NewReader(testArchive))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requested
+}
+
+func checkDownloaded(t *testing.T, dest string) {
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, testArchive) {
+		t.Errorf("downloaded %d bytes, want the %d bytes of the archive", len(data), len(testArchive))
+	}
+	if _, err := os.Stat(fmt.Sprint(dest, PARTIAL_EXT)); !os.IsNotExist(err) {
+		t.Error("the partial file is left behind")
+	}
+}
+
+func TestDownloadResumesPartialFile(t *testing.T) {
+	server, requested := testFileServer(t, true)
+	dest := filepath.Join(t.TempDir(), "mod.zip")
+	os.WriteFile(fmt.Sprint(dest, PARTIAL_EXT), testArchive[:4000], 0644)
+
+	progress := int64(0)
+	downloader := NewDownloader()
+	downloader.OnProgress = func(received int64, total int64) {
+		progress = received
+		if total != int64(len(testArchive)) {
+			t.Errorf("total = %d, want %d", total, len(testArchive))
+		}
+	}
+	if err := downloader.Download(server.URL+"/mod.zip", dest); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, dest)
+	if len(*requested) != 1 || (*requested)[0] != "bytes=4000-" {
+		t.Errorf("ranges requested = %v, want bytes=4000-", *requested)
+	}
+	if progress != int64(len(testArchive)) {
+		t.Errorf("progress = %d, want %d", progress, len(testArchive))
+	}
+}
+
+func TestDownloadCompletePartialFile(t *testing.T) {
+	// the server answers 416 when the partial file already holds everything
+	server, requested := testFileServer(t, true)
+	dest := filepath.Join(t.TempDir(), "mod.zip")
+	os.WriteFile(fmt.Sprint(dest, PARTIAL_EXT), testArchive, 0644)
+
+	if err := NewDownloader().Download(server.URL+"/mod.zip", dest); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, dest)
+	if len(*requested) != 1 || (*requested)[0] != fmt.Sprint("bytes=", len(testArchive), "-") {
+		t.Errorf("ranges requested = %v", *requested)
+	}
+}
+
+func TestDownloadWithoutRanges(t *testing.T) {
+	// a server without range support sends the whole file again, replacing the partial file
+	server, _ := testFileServer(t, false)
+	dest := filepath.Join(t.TempDir(), "mod.zip")
+	os.WriteFile(fmt.Sprint(dest, PARTIAL_EXT), []byte("stale bytes of an older file"), 0644)
+
+	if err := NewDownloader().Download(server.URL+"/mod.zip", dest); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, dest)
+}
+
+func TestDownloadFailsOnError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	dest := filepath.Join(t.TempDir(), "mod.zip")
+	err := NewDownloader().Download(server.URL+"/mod.zip", dest)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("error = %v, want 404", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Error("a file was saved")
+	}
+}
+
+func TestDownloadGithubRelease(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/repos/test/mod/releases/tags/v1.0", func(writer http.ResponseWriter, request *http.Request) {
+		fmt.Fprintf(writer, `{"tag_name": "v1.0", "assets": [
+			{"name": "mod-src.tar.gz", "browser_download_url": "%[1]s/src"},
+			{"name": "mod-1.0.zip", "browser_download_url": "%[1]s/mod.zip"}]}`, server.URL)
+	})
+	mux.HandleFunc("/mod.zip", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write(testArchive)
+	})
+	previous := GITHUB_API_URL
+	GITHUB_API_URL = server.URL
+	defer func() { GITHUB_API_URL = previous }()
+
+	folder := t.TempDir()
+	step := DownloadStep{Type: GITHUB_RELEASE, Repo: "test/mod", Tag: "v1.0", Asset: "mod-*.zip"}
+	file, err := NewDownloader().DownloadFromSource(step, folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.FileName != "mod-1.0.zip" || file.Version != "v1.0" {
+		t.Errorf("file = %+v, want mod-1.0.zip of v1.0", file)
+	}
+	checkDownloaded(t, filepath.Join(folder, "mod-1.0.zip"))
+
+	step.Tag = "v2.0"
+	if _, err := NewDownloader().DownloadFromSource(step, folder); err == nil {
+		t.Error("a missing release was downloaded")
+	}
+}
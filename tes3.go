@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// TES3 plugins (.esp, .esm, .omwaddon) start with a TES3 record holding the
+// HEDR subrecord followed by a MAST/DATA pair for each master file.
+
+// headers only hold a few hundred bytes per master, anything larger is a broken file
+const MAX_TES3_HEADER_SIZE = 1 << 20
+
+type PluginMaster struct {
+	Name string
+	Size uint64 // size of the master when the plugin was saved
+}
+
+type PluginHeader struct {
+	Version     float32
+	FileType    uint32
+	Author      string
+	Description string
+	RecordCount uint32
+	Masters     []PluginMaster
+}
+
+func (header PluginHeader) MasterNames() []string {
+	names := []string{}
+	for _, master := range header.Masters {
+		names = append(names, master.Name)
+	}
+	return names
+}
+
+// cString reads a null padded string
+func cString(data []byte) string {
+	if index := bytes.IndexByte(data, 0); index >= 0 {
+		data = data[:index]
+	}
+	return string(data)
+}
+
+func ParsePluginHeader(reader io.Reader) (PluginHeader, error) {
+	header := PluginHeader{Masters: []PluginMaster{}}
+
+	// record name, size, unused header, flags
+	recordHeader := make([]byte, 16)
+	if _, err := io.ReadFull(reader, recordHeader); err != nil {
+		return header, fmt.Errorf("reading record header: %v", err)
+	}
+	if string(recordHeader[0:4]) != "TES3" {
+		return header, fmt.Errorf("not a TES3 plugin")
+	}
+	recordSize := binary.LittleEndian.Uint32(recordHeader[4:8])
+	if recordSize > MAX_TES3_HEADER_SIZE {
+		return header, fmt.Errorf("TES3 record is %d bytes, too large for a plugin header", recordSize)
+	}
+
+	record := make([]byte, recordSize)
+	if _, err := io.ReadFull(reader, record); err != nil {
+		return header, fmt.Errorf("reading TES3 record: %v", err)
+	}
+
+	for offset := 0; offset+8 <= len(record); {
+		name := string(record[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(record[offset+4 : offset+8]))
+		offset += 8
+		if offset+size > len(record) {
+			return header, fmt.Errorf("subrecord %s runs past the end of the TES3 record", name)
+		}
+		data := record[offset : offset+size]
+		offset += size
+
+		switch name {
+		case "HEDR":
+			if len(data) < 300 {
+				return header, fmt.Errorf("HEDR subrecord is %d bytes, expected 300", len(data))
+			}
+			header.Version = math.Float32frombits(binary.LittleEndian.Uint32(data[0:4]))
+			header.FileType = binary.LittleEndian.Uint32(data[4:8])
+			header.Author = cString(data[8:40])
+			header.Description = cString(data[40:296])
+			header.RecordCount = binary.LittleEndian.Uint32(data[296:300])
+		case "MAST":
+			header.Masters = append(header.Masters, PluginMaster{Name: cString(data)})
+		case "DATA":
+			if len(header.Masters) > 0 && len(data) >= 8 {
+				header.Masters[len(header.Masters)-1].Size = binary.LittleEndian.Uint64(data)
+			}
+		}
+	}
+
+	return header, nil
+}
+
+func ReadPluginHeader(path string) (PluginHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return PluginHeader{Masters: []PluginMaster{}}, err
+	}
+	defer file.Close()
+	return ParsePluginHeader(file)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func uint32Bytes(value uint32) []byte {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, value)
+	return data
+}
+
+func subrecord(name string, data []byte) []byte {
+	record := append([]byte(name), uint32Bytes(uint32(len(data)))...)
+	return append(record, data...)
+}
+
+// testPluginHeader builds a TES3 record with a HEDR subrecord and a MAST/DATA pair for every master
+func testPluginHeader(author string, masters []PluginMaster) []byte {
+	hedr := make([]byte, 300)
+	binary.LittleEndian.PutUint32(hedr[0:4], math.Float32bits(1.3))
+	binary.LittleEndian.PutUint32(hedr[4:8], 0)
+	copy(hedr[8:40], author)
+	copy(hedr[40:296], "A test plugin")
+	binary.LittleEndian.PutUint32(hedr[296:300], 12)
+
+	record := subrecord("HEDR", hedr)
+	for _, master := range masters {
+		record = append(record, subrecord("MAST", append([]byte(master.Name), 0))...)
+		size := make([]byte, 8)
+		binary.LittleEndian.PutUint64(size, master.Size)
+		record = append(record, subrecord("DATA", size)...)
+	}
+
+	header := append([]byte("TES3"), uint32Bytes(uint32(len(record)))...)
+	header = append(header, make([]byte, 8)...)
+	return append(header, record...)
+}
+
+func TestParsePluginHeader(t *testing.T) {
+	masters := []PluginMaster{
+		{Name: "Morrowind.esm", Size: 79837557},
+		{Name: "Tribunal.esm", Size: 4565686},
+		{Name: "Bloodmoon.esm", Size: 9631798},
+		{Name: "Tamriel_Data.esm", Size: 49012741},
+	}
+	header, err := ParsePluginHeader(bytes.NewReader(testPluginHeader("Aradir", masters)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 1.3 || header.Author != "Aradir" || header.Description != "A test plugin" || header.RecordCount != 12 {
+		t.Errorf("header = %+v", header)
+	}
+	if fmt.Sprint(header.Masters) != fmt.Sprint(masters) {
+		t.Errorf("masters = %v, want %v", header.Masters, masters)
+	}
+
+	header, err = ParsePluginHeader(bytes.NewReader(testPluginHeader("", nil)))
+	if err != nil || len(header.Masters) != 0 {
+		t.Errorf("masters = %v, %v, want none", header.Masters, err)
+	}
+}
+
+func TestParsePluginHeaderErrors(t *testing.T) {
+	valid := testPluginHeader("Aradir", []PluginMaster{{Name: "Morrowind.esm", Size: 79837557}})
+	oversized := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint32(oversized[4:8], MAX_TES3_HEADER_SIZE+1)
+	// the record size is shrunk, so the DATA subrecord no longer fits in it
+	cutSubrecord := append([]byte{}, valid[:len(valid)-4]...)
+	binary.LittleEndian.PutUint32(cutSubrecord[4:8], uint32(len(cutSubrecord)-16))
+	shortHedr := append([]byte("TES3"), uint32Bytes(8+100)...)
+	shortHedr = append(shortHedr, make([]byte, 8)...)
+	shortHedr = append(shortHedr, subrecord("HEDR", make([]byte, 100))...)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		problem string
+	}{
+		{"empty", nil, "reading record header"},
+		{"not a plugin", append([]byte("TES4"), valid[4:]...), "not a TES3 plugin"},
+		{"oversized", oversized, "too large for a plugin header"},
+		{"truncated record", valid[:len(valid)-10], "reading TES3 record"},
+		{"truncated subrecord", cutSubrecord, "subrecord DATA runs past the end"},
+		{"short HEDR", shortHedr, "HEDR subrecord is 100 bytes"},
+	}
+	for _, test := range tests {
+		_, err := ParsePluginHeader(bytes.NewReader(test.data))
+		if err == nil || !strings.Contains(err.Error(), test.problem) {
+			t.Errorf("%s: error = %v, want %q", test.name, err, test.problem)
+		}
+	}
+}
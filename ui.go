@@ -4,9 +4,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
 	"strings"
+	"time"
 
+	"github.com/go-rod/rod"
 	reflections "github.com/oleiade/reflections"
 )
 
@@ -56,6 +59,15 @@ func RunTerminal() {
 		boolMap[boolDef.name] = flag.Bool(boolDef.name, boolDef.defaultVal, boolDef.description)
 	}
 
+	// Register a flag for each option of the chosen preset, ie -option.groundcover=false
+	optionMap := make(map[string]*bool)
+	if optionPreset, err := ResolvePreset(presetFromArgs(os.Args[1:], prefs.Preset)); err == nil {
+		optionValues := ResolveOptionValues(optionPreset.Options, prefs.Options)
+		for _, option := range optionPreset.Options {
+			optionMap[option.Name] = flag.Bool(fmt.Sprint("option.", option.Name), optionValues[option.Name], option.Description)
+		}
+	}
+
 	// Execute flags
 	flag.Parse()
 
@@ -78,16 +90,41 @@ func RunTerminal() {
 		log.Fatal("Preset field is unset")
 	}
 
+	// stop before downloading anything if the preset would fail part way through
+	if issues := ValidatePreset(prefs.Preset); len(issues) > 0 {
+		for _, issue := range issues {
+			fmt.Println(issue.String())
+		}
+		log.Fatal("Preset is invalid, run `mw-aradir validate` for details")
+	}
+
 	configFileName := prefs.Preset
 	downloadFolder := prefs.Downloads
-	var config = ModListConfig{}
+	var config = ReadPreset(fmt.Sprint(configFileName, ".yaml"))
 	var manifest = ManifestListConfig{}
+
+	// skip every step the user switched off before anything is downloaded
+	optionChoices := make(map[string]bool)
+	for name, value := range prefs.Options {
+		optionChoices[name] = value
+	}
+	for name, value := range optionMap {
+		optionChoices[name] = *value
+	}
+	config, err := ApplyPresetOptions(config, ResolveOptionValues(config.Options, optionChoices))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	if !prefs.Nodownload {
-		config, manifest = DownloadMods(configFileName, downloadFolder)
+		var failed []DownloadStep
+		manifest, failed = DownloadMods(configFileName, config, prefs)
+		// the unpack steps of a failed download would find no archive
+		if len(failed) > 0 {
+			log.Fatal("Stopping before unpacking, the finished downloads are kept for the next run")
+		}
 	} else {
-		configName := fmt.Sprint(prefs.Preset, ".yaml")
 		manifestName := fmt.Sprint(prefs.Preset, "-manifest.yaml")
-		config = ReadPreset(configName)
 		manifest = ReadManifest(manifestName)
 	}
 
@@ -97,6 +134,157 @@ func RunTerminal() {
 	RunOpenMW(openMWExe, configPath)
 }
 
+// presetFromArgs finds a -preset flag before the flags are parsed, so preset options can be registered
+func presetFromArgs(args []string, defaultPreset string) string {
+	for i, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if strings.HasPrefix(name, "preset=") {
+			return strings.TrimPrefix(name, "preset=")
+		}
+		if name == "preset" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return defaultPreset
+}
+
+// RunValidate checks presets without downloading or unpacking anything.
+// Presets can be passed as arguments, otherwise the preferences preset is used.
+func RunValidate(args []string) {
+	prefs := ReadPrefs("preferences.yaml")
+	validateFlags := flag.NewFlagSet("validate", flag.ExitOnError)
+	preset := validateFlags.String("preset", prefs.Preset, "preset ID")
+	validateFlags.Parse(args)
+
+	presets := validateFlags.Args()
+	if len(presets) == 0 {
+		presets = []string{*preset}
+	}
+
+	issueCount := 0
+	for _, name := range presets {
+		if name == "" {
+			log.Fatal("Preset field is unset")
+		}
+		issues := append(ValidatePreset(name), ValidateGeneratedConfig(name)...)
+		for _, issue := range issues {
+			fmt.Println(issue.String())
+		}
+		if len(issues) == 0 {
+			fmt.Println(fmt.Sprint(name, ": ok"))
+		}
+		issueCount += len(issues)
+	}
+
+	if issueCount > 0 {
+		fmt.Println(fmt.Sprint(issueCount, " problem(s) found"))
+		os.Exit(1)
+	}
+}
+
+// RunOutdated lists the downloaded mods of a preset that have newer files
+func RunOutdated(args []string) {
+	prefs := ReadPrefs("preferences.yaml")
+	outdatedFlags := flag.NewFlagSet("outdated", flag.ExitOnError)
+	preset := outdatedFlags.String("preset", prefs.Preset, "preset ID")
+	outdatedFlags.Parse(args)
+	if *preset == "" {
+		log.Fatal("Preset field is unset")
+	}
+
+	config := ReadPreset(*preset)
+	config, err := ApplyPresetOptions(config, ResolveOptionValues(config.Options, prefs.Options))
+	if err != nil {
+		log.Fatal(err)
+	}
+	manifest := ReadManifest(fmt.Sprint(*preset, "-manifest.yaml"))
+
+	var nexusFiles NexusFileSource
+	var page *rod.Page
+	if api := NewNexusApi(prefs); api != nil {
+		nexusFiles = api.ListFiles
+	} else {
+		profile, err := ReadSiteProfile(NEXUS)
+		if err != nil {
+			log.Fatal(err)
+		}
+		limiter := RateLimiter{interval: DEFAULT_DOWNLOAD_INTERVAL}
+		nexusFiles = func(modId int32) (NexusFileList, error) {
+			if page == nil {
+				page = createRodHandler(prefs.Downloads).MustPage(NEXUS_MODS_URL)
+			}
+			limiter.Wait()
+			return ScrapeNexusFiles(page, profile, modId)
+		}
+	}
+
+	outdated, errs := FindOutdatedMods(config.DownloadSteps, manifest, nexusFiles, NewDownloader())
+	if page != nil {
+		page.Browser().Close()
+	}
+	for _, err := range errs {
+		fmt.Println(fmt.Sprint("Could not check ", err))
+	}
+	for _, mod := range outdated {
+		fmt.Println(mod.String())
+	}
+	if len(outdated) == 0 {
+		fmt.Println(fmt.Sprint(*preset, ": every checked download is up to date"))
+	} else {
+		fmt.Println(fmt.Sprint(len(outdated), " download(s) have newer files"))
+	}
+}
+
+// RunReconcile rebuilds the manifest of a preset from the archives in the downloads folder
+func RunReconcile(args []string) {
+	prefs := ReadPrefs("preferences.yaml")
+	reconcileFlags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	preset := reconcileFlags.String("preset", prefs.Preset, "preset ID")
+	reconcileFlags.Parse(args)
+	if *preset == "" {
+		log.Fatal("Preset field is unset")
+	}
+
+	config := ReadPreset(*preset)
+	config, err := ApplyPresetOptions(config, ResolveOptionValues(config.Options, prefs.Options))
+	if err != nil {
+		log.Fatal(err)
+	}
+	manifest := ManifestListConfig{ListName: *preset, Created: time.Now().Unix()}
+	manifestName := fmt.Sprint(*preset, "-manifest.yaml")
+	if exists, _ := Exists(fmt.Sprint("./manifests/", manifestName)); exists {
+		manifest = ReadManifest(manifestName)
+	}
+
+	kept, matches, unmatched := ReconcileDownloads(config, manifest, prefs.Downloads)
+	for _, record := range kept {
+		fmt.Println(fmt.Sprint("Keeping ", record.FileDisplayName, ": ", record.FileName))
+	}
+	for _, match := range matches {
+		fmt.Println(fmt.Sprint("Found ", match.Step.SiteFileName, ": ", match.Record.FileName, " (", strings.Join(match.Reasons, ", "), ")"))
+	}
+	for _, step := range unmatched {
+		fmt.Println(fmt.Sprint("Missing ", step.SiteFileName, " (", step.ModId, ")"))
+	}
+	if len(matches) == 0 {
+		fmt.Println("No new archives found, the manifest is unchanged")
+		return
+	}
+
+	if !askYesNo(fmt.Sprint("Write ", len(kept)+len(matches), " record(s) to manifests/", manifestName, "?")) {
+		return
+	}
+	manifest.ListName = *preset
+	manifest.Records = kept
+	for _, match := range matches {
+		manifest.Records = append(manifest.Records, match.Record)
+	}
+	WriteManifest(&manifest, *preset)
+}
+
 func RunOpenMW(path string, configPath string) {
 	config := fmt.Sprint("--config=", configPath)
 	// replace := fmt.Sprint("--replace=config")
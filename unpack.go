@@ -1,17 +1,13 @@
 package main
 
 import (
-	"archive/zip"
 	"bufio"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/bodgit/sevenzip"
-	marchive "github.com/mholt/archiver/v3"
 	cp "github.com/otiai10/copy"
 )
 
@@ -19,85 +15,26 @@ const SKIP_EXTRACT = false
 const USE_PRESET_CONFIGS = true
 const SKIP_COPY = false
 
-var SUPPORTED_ARCHIVE_FORMATS = [3]string{".zip", ".7z", ".rar"}
-
 func checkError(e error) {
 	if e != nil {
 		fmt.Println(e.Error())
 	}
 }
 
+// PathIncludesArchive reports whether a path ends in the name of an archive format
 func PathIncludesArchive(path string) bool {
-	for _, val := range SUPPORTED_ARCHIVE_FORMATS {
-		if strings.Contains(path, val) {
-			return true
-		}
-	}
-	return false
-}
-
-func cloneZipItem(f *zip.File, dest string) {
-	// Create full directory path
-	path := filepath.Join(dest, f.Name)
-	err := os.MkdirAll(filepath.Dir(path), os.ModeDir|os.ModePerm)
-	checkError(err)
-
-	// Clone if item is a file
-	rc, err := f.Open()
-	checkError(err)
-	if !f.FileInfo().IsDir() {
-		// Use os.Create() since Zip don't store file permissions.
-		fileCopy, err := os.Create(path)
-		checkError(err)
-		_, err = io.Copy(fileCopy, rc)
-		fileCopy.Close()
-		checkError(err)
-	}
-	rc.Close()
-}
-
-func clone7ZipItem(f *sevenzip.File, dest string) {
-	path := filepath.Join(dest, f.Name)
-	err := os.MkdirAll(filepath.Dir(path), os.ModeDir|os.ModePerm)
-	checkError(err)
-
-	// Clone if item is a file
-	rc, err := f.Open()
-	checkError(err)
-	if !f.FileInfo().IsDir() {
-		// Use os.Create() since Zip don't store file permissions.
-		fileCopy, err := os.Create(path)
-		checkError(err)
-		_, err = io.Copy(fileCopy, rc)
-		fileCopy.Close()
-		checkError(err)
-	}
-	rc.Close()
+	return archiveExt(path) != ""
 }
 
-func ExtractArchiveRarArchive(zip_path, dest string) {
-	err := marchive.Unarchive(zip_path, dest)
-	if err != nil && !strings.Contains(err.Error(), "already exists") {
-		log.Fatal(err)
-	}
-}
-
-func Extract(zip_path, dest string) {
-	r, err := zip.OpenReader(zip_path)
-	checkError(err)
-	defer r.Close()
-	for _, f := range r.File {
-		cloneZipItem(f, dest)
-	}
-}
-
-func Extract7Zip(zip_path, dest string) {
-	r, err := sevenzip.OpenReader(zip_path)
-	checkError(err)
-	defer r.Close()
-	for _, f := range r.File {
-		clone7ZipItem(f, dest)
+// archiveExt is the archive extension a file name ends in, or ""
+func archiveExt(filename string) string {
+	lower := strings.ToLower(filename)
+	for _, val := range SUPPORTED_ARCHIVE_FORMATS {
+		if strings.HasSuffix(lower, val) {
+			return filename[len(filename)-len(val):]
+		}
 	}
+	return ""
 }
 
 const DATA = "DATA"                               // data to openmw.cfg
@@ -109,14 +46,16 @@ const DEELETE_LIST = "DELETE_LIST"                // list of file paths in data
 const DELETE_LIST_BY_FILE = "DELETE_LIST_BY_FILE" // list of directories
 const INSTALL_TO_OMW = "INSTALL_TO_OMW_FOLDER"    // add content to openMW folder where openmw.cfg is
 const DELTA = "DELTA_PLUGIN"                      // run delta plugin tmerge
+const GROUNDCOVER = "GROUNDCOVER"                 // groundcover plugins, loaded instead of content
+const FALLBACK_ARCHIVE = "FALLBACK_ARCHIVE"       // bsa archives to openmw.cfg
+const ENCODING = "ENCODING"                       // font encoding of the game data
+
+var PLUGIN_EXTS = []string{".esp", ".esm", ".omwaddon", ".omwgame"}
+var ENCODINGS = []string{"win1250", "win1251", "win1252"}
 
+// getFileName is the name of an archive without its extension, the folder it is extracted to
 func getFileName(filename string) string {
-	for _, val := range SUPPORTED_ARCHIVE_FORMATS {
-		if strings.Contains(filename, val) {
-			return strings.Replace(filename, val, "", 1)
-		}
-	}
-	return filename
+	return strings.TrimSuffix(filename, archiveExt(filename))
 }
 
 func readLines(path string) ([]string, error) {
@@ -153,99 +92,48 @@ func remove(slice []string, s int) []string {
 	return append(slice[:s], slice[s+1:]...)
 }
 
-func filterArrayByString(lines []string, filters []string) []string {
-	var finalLines []string
-	for _, line := range lines {
-		var matched = false
-		for _, filter := range filters {
-			if line == filter {
-				matched = true
-			}
-		}
-		if !matched {
-			finalLines = append(finalLines, line)
-		}
-	}
-	return finalLines
-}
-
-var CONFIG_FILTERS = []string{"data=", "resources="}
-var CONTENT_FILTERS = []string{"content=Morrowind.esm",
-	"content=Tribunal.esm",
-	"content=Bloodmoon.esm"}
-
-func LoadBlankConfigArrays(filepath string) ([]string, []string) {
-	configLines, err := readLines(filepath)
-	var contentLines []string
-	var newConfigLines []string
-	checkError(err)
+var CONTENT_FILTERS = []string{"Morrowind.esm",
+	"Tribunal.esm",
+	"Bloodmoon.esm"}
 
-	for _, line := range configLines {
-		if (!strings.Contains(line, "content=")) || strings.Contains(line, "Morrowind/Data Files") {
-			newConfigLines = append(newConfigLines, line)
-		}
-		if strings.Contains(line, "content=") {
-			contentLines = append(contentLines, line)
-		}
+// LoadBaseConfig generates the openmw.cfg a preset starts from out of the game data folder
+func LoadBaseConfig(prefs PreferencesConfig) *OpenMWConfig {
+	encodingName := prefs.Encoding
+	if encodingName == "" {
+		encodingName = DEFAULT_ENCODING
 	}
-
-	newConfigLines = RemoveDuplicateStr(newConfigLines)
-	contentLines = RemoveDuplicateStr(contentLines)
-
-	return newConfigLines, contentLines
+	config, err := GenerateBaseConfig(prefs.Gamedata, encodingName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return config
 }
 
-func LoadSettingsArray(filepath string) []string {
-	settingsLines, err := readLines(fmt.Sprint(filepath))
-	var newLines []string
-	checkError(err)
-
-	for _, line := range settingsLines {
-		newLines = append(newLines, line)
+// LoadBaseSettings reads the settings.cfg a preset starts from, the user's own or an empty one.
+// The preset's settings.cfg from the last run is not used, it keeps keys of options switched off since.
+func LoadBaseSettings(prefs PreferencesConfig) *SettingsConfig {
+	settings, err := ReadSettingsConfig(fmt.Sprint(prefs.Settings, "/", "settings.cfg"))
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	return newLines
+	return settings
 }
 
-func UnpackSettingsStep(step UnpackStep, record ManifestRecord, filepath string, configLoc string) {
-	var newLines []string
-
-	// write lines into config
-	settingsPath := fmt.Sprint(configLoc, "/", "settings.cfg")
-	settingsLines := LoadSettingsArray(settingsPath)
-	for _, setting := range settingsLines {
-		newLines = append(newLines, setting)
+// UnpackSettingsStep sets each key in its section of settings.cfg, overwriting any existing value
+func UnpackSettingsStep(step UnpackStep, settings *SettingsConfig) {
+	values, err := ParseSettingsStep(step.Data)
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	for _, setting := range step.Data {
-		newLines = append(newLines, setting)
+	for _, setting := range values {
+		settings.Set(setting.Section, setting.Key, setting.Value)
 	}
-
-	newLines = RemoveDuplicateStr(newLines)
-
-	writeLines(newLines, settingsPath)
 }
 
-func UnpackResourcesStep(step UnpackStep, record ManifestRecord, filepath string, configLines []string, contentLines []string, configLoc string) {
-	var newLines []string
-
-	// write lines into config
-	configPath := fmt.Sprint(configLoc, "/", "openmw.cfg")
-	for _, line := range configLines {
-		newLines = append(newLines, line)
-	}
-
+func UnpackResourcesStep(step UnpackStep, record ManifestRecord, filepath string, config *OpenMWConfig) {
 	for _, path := range step.Data {
-		newLine := fmt.Sprint("resources=\"", filepath, "/", getFileName(record.FileName), "/", path, "\"")
-		newLines = append(newLines, newLine)
-	}
-
-	// reapply content lines
-	for _, content := range contentLines {
-		newLines = append(newLines, content)
+		config.Add(CFG_RESOURCES, fmt.Sprint(filepath, "/", getFileName(record.FileName), "/", path))
 	}
-
-	writeLines(newLines, configPath)
 }
 
 // this method is using the wrong record for the file name, should not using siteFileName
@@ -265,99 +153,109 @@ func UnpackInstallToOMWFolder(step UnpackStep, record ManifestRecord, filepath s
 	}
 }
 
-func AddBaseContent(filepath string) {
-	configLines, err := readLines(filepath)
-	checkError(err)
-
-	var missingPlugins []string
-	for _, configLine := range configLines {
-		for _, content := range CONTENT_FILTERS {
-			if configLine == content {
-				missingPlugins = append(missingPlugins, content)
-			}
+// AddBaseContent moves the game plugins to the top of the content list, since this effects load order
+func AddBaseContent(config *OpenMWConfig) {
+	contentList := []string{}
+	for _, content := range CONTENT_FILTERS {
+		if config.Has(CFG_CONTENT, content) {
+			contentList = append(contentList, content)
 		}
 	}
-
-	// insert at the top of the content list, since this effects load order
-	if len(missingPlugins) > 0 {
-		index := len(configLines) - 1
-		for configIndex, val := range configLines {
-			if strings.Contains(val, "content=") {
-				index = configIndex
-				break
-			}
+	for _, content := range config.Values(CFG_CONTENT) {
+		if !sliceContains(CONTENT_FILTERS, content) {
+			contentList = append(contentList, content)
 		}
-		configLines = insertIntoSlice(configLines, index, missingPlugins)
 	}
-
-	writeLines(configLines, filepath)
+	config.SetValues(CFG_CONTENT, contentList)
 }
 
-func AddDeltaContent(filepath string, deltaFolder string) {
-	configLines, err := readLines(filepath)
-	checkError(err)
-
+func AddDeltaContent(config *OpenMWConfig, deltaFolder string) {
 	// openmw.exe --config merges base cfg with the input cfg, meaning -
 	// we need to remove morrowind, tribunal, and bloodmoon plugins or it wont run
-	configLines = filterArrayByString(configLines, CONTENT_FILTERS)
-	deltaData := fmt.Sprint("data=\"", deltaFolder, "\"")
-	configLines = append(configLines, deltaData, "content=DeltaPluginMerged.omwaddon")
-	writeLines(configLines, filepath)
+	for _, content := range CONTENT_FILTERS {
+		config.Remove(CFG_CONTENT, content)
+	}
+	config.Add(CFG_DATA, deltaFolder)
+	config.Add(CFG_CONTENT, "DeltaPluginMerged.omwaddon")
 }
 
-func UnpackContentStep(filepath string, data []string) {
-	configLines, err := readLines(filepath)
-	checkError(err)
-
+func UnpackContentStep(config *OpenMWConfig, data []string) {
 	for _, val := range data {
-		content := fmt.Sprint("content=", val)
-		configLines = append(configLines, content)
+		if config.Has(CFG_GROUNDCOVER, val) {
+			fmt.Println(fmt.Sprint("Skipping content ", val, ", it is already loaded as groundcover"))
+			continue
+		}
+		config.Add(CFG_CONTENT, val)
 	}
-
-	writeLines(configLines, filepath)
 }
 
-func UnpackDataStep(step UnpackStep, record ManifestRecord, filepath string, configLines []string, contentLines []string, configLoc string) {
-	var newLines []string
-
-	// write lines into config
-	configPath := fmt.Sprint(configLoc, "/", "openmw.cfg")
-	for _, line := range configLines {
-		newLines = append(newLines, line)
+// FindInDataDirs looks for a file in the data directories of openmw.cfg, ignoring case like OpenMW does
+func FindInDataDirs(config *OpenMWConfig, fileName string) (string, bool) {
+	dataDirs := append(config.Values(CFG_DATA), config.Values(CFG_DATA_LOCAL)...)
+	// later data directories override earlier ones
+	for i := len(dataDirs) - 1; i >= 0; i-- {
+		dir := dataDirs[i]
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if !file.IsDir() && strings.EqualFold(file.Name(), fileName) {
+				return filepath.Join(dir, file.Name()), true
+			}
+		}
 	}
+	return "", false
+}
 
-	for _, path := range step.Data {
-		newLine := fmt.Sprint("data=\"", filepath, "/", getFileName(record.FileName), "/", path, "\"")
-		newLines = append(newLines, newLine)
+// UnpackGroundcoverStep adds groundcover plugins, which must not also be loaded as content
+func UnpackGroundcoverStep(step UnpackStep, config *OpenMWConfig) {
+	for _, plugin := range step.Data {
+		if _, found := FindInDataDirs(config, plugin); !found {
+			log.Fatalf("Groundcover plugin %s was not found in any data directory", plugin)
+		}
+		if config.Remove(CFG_CONTENT, plugin) {
+			fmt.Println(fmt.Sprint("Removed content ", plugin, ", it is loaded as groundcover"))
+		}
+		config.Add(CFG_GROUNDCOVER, plugin)
 	}
+}
 
-	// reapply content lines
-	for _, content := range contentLines {
-		newLines = append(newLines, content)
+func UnpackFallbackArchiveStep(step UnpackStep, config *OpenMWConfig) {
+	for _, archive := range step.Data {
+		if _, found := FindInDataDirs(config, archive); !found {
+			log.Fatalf("Fallback archive %s was not found in any data directory", archive)
+		}
+		config.Add(CFG_FALLBACK_ARCHIVE, archive)
 	}
-
-	writeLines(newLines, configPath)
 }
 
-func UnpackDataDirectStep(step UnpackStep, record ManifestRecord, filepath string, configLines []string, contentLines []string, configLoc string) {
-	var newLines []string
-
-	// write lines into config
-	configPath := fmt.Sprint(configLoc, "/", "openmw.cfg")
-	for _, line := range configLines {
-		newLines = append(newLines, line)
-	}
+func UnpackEncodingStep(step UnpackStep, config *OpenMWConfig) {
+	config.RemoveKey(CFG_ENCODING)
+	config.Add(CFG_ENCODING, step.Data[0])
+}
 
-	for _, fallback := range step.Data {
-		newLines = append(newLines, fallback)
+// UnpackDataStep adds data directories and returns them, so load order rules can find the mod they belong to
+func UnpackDataStep(step UnpackStep, record ManifestRecord, filepath string, config *OpenMWConfig) []string {
+	dataPaths := []string{}
+	for _, path := range step.Data {
+		dataPath := fmt.Sprint(filepath, "/", getFileName(record.FileName), "/", path)
+		config.Add(CFG_DATA, dataPath)
+		dataPaths = append(dataPaths, dataPath)
 	}
+	return dataPaths
+}
 
-	// reapply content lines
-	for _, content := range contentLines {
-		newLines = append(newLines, content)
+// UnpackDataDirectStep adds raw openmw.cfg lines, ie "fallback-archive=PT_Data.bsa"
+func UnpackDataDirectStep(step UnpackStep, config *OpenMWConfig) {
+	for _, line := range step.Data {
+		entry := ParseConfigLine(line)
+		if !entry.IsSetting() {
+			fmt.Println(fmt.Sprint("Skipping malformed config line: ", line))
+			continue
+		}
+		config.Add(entry.Key, entry.Value)
 	}
-
-	writeLines(newLines, configPath)
 }
 
 func UnpackDeleteStep(step UnpackStep, record ManifestRecord, filepath string) {
@@ -381,15 +279,26 @@ func UnpackDeleteByFileStep(step UnpackStep, record ManifestRecord, filepath str
 	}
 }
 
-func findFileManifestRecord(records []ManifestRecord, modId int32, index int16) ManifestRecord {
-	var relevantRecords []ManifestRecord
-	for _, step := range records {
+// findFileManifestRecord finds the manifest record for the fileIndex-th download step of a mod
+func findFileManifestRecord(records []ManifestRecord, downloads []DownloadStep, modId int32, index int16) ManifestRecord {
+	var relevantSteps []DownloadStep
+	for _, step := range downloads {
 		if step.ModId == modId {
-			relevantRecords = append(relevantRecords, step)
+			relevantSteps = append(relevantSteps, step)
 		}
 	}
+	if index < 0 || int(index) >= len(relevantSteps) {
+		log.Fatalf("Mod %d has no download step with fileIndex %d", modId, index)
+	}
 
-	return relevantRecords[index]
+	siteFileName := relevantSteps[index].SiteFileName
+	for _, record := range records {
+		if record.ModId == modId && record.FileDisplayName == siteFileName {
+			return record
+		}
+	}
+	log.Fatalf("Mod %d file %q has not been downloaded", modId, siteFileName)
+	return ManifestRecord{}
 }
 
 func GetCurrentDirPath() string {
@@ -407,20 +316,92 @@ func UnpackMods(config ModListConfig, manifest ManifestListConfig, downloadFolde
 	}
 
 	if !SKIP_EXTRACT {
-		for _, val := range manifest.Records {
+		manifestChanged := false
+		jobs := []ExtractJob{}
+		jobLocations := []string{}
+		missing := []string{}
+		for i, val := range manifest.Records {
 			zipPath := fmt.Sprint(downloadFolder, "/", val.FileName)
 			downloadName := getFileName(val.FileName)
 			location := fmt.Sprint(modInstallFolder, "/", downloadName)
-			extracted, err := Exists(location)
-			checkError(err)
-
-			if strings.Contains(val.FileName, ".zip") && !extracted {
-				Extract(zipPath, location)
-			} else if strings.Contains(val.FileName, ".7z") && !extracted {
-				Extract7Zip(zipPath, location)
-			} else if strings.Contains(val.FileName, ".rar") && !extracted {
-				ExtractArchiveRarArchive(zipPath, location)
+
+			// archives can be deleted once they are extracted, with everything the preset needs
+			if exists, _ := Exists(zipPath); !exists {
+				marker, err := ReadExtractMarker(location)
+				if err != nil {
+					missing = append(missing, fmt.Sprint(val.FileName, " is missing and was never fully extracted"))
+				} else if prefs.SelectiveExtract && len(uncoveredPaths(marker.Paths, ReferencedArchivePaths(config, val))) > 0 {
+					missing = append(missing, fmt.Sprint(val.FileName, " is missing and only part of it was extracted"))
+				}
+				continue
+			}
+
+			// the marker of an extracted folder is compared with the hash of the archive
+			if manifest.Records[i].Sha256 == "" {
+				if hash, err := FileSha256(zipPath); err == nil {
+					manifest.Records[i].Sha256 = hash
+				}
+			}
+			var paths []string
+			if prefs.SelectiveExtract {
+				paths = ReferencedArchivePaths(config, val)
+			}
+			// a complete folder is kept, or only gets the paths it is missing
+			var extracted []string
+			if marker, complete := CompleteExtraction(location, manifest.Records[i]); complete {
+				uncovered := uncoveredPaths(marker.Paths, paths)
+				if len(marker.Paths) == 0 || (paths != nil && len(uncovered) == 0) {
+					manifestChanged = manifestChanged || manifest.Records[i] != val
+					continue
+				}
+				if paths != nil {
+					extracted = marker.Paths
+					paths = uncovered
+				}
+			}
+
+			// archives that don't match their pins are never extracted
+			if step, ok := findDownloadStep(config.DownloadSteps, val); ok {
+				if err := CheckArchive(zipPath, step, &manifest.Records[i]); err != nil {
+					log.Fatalf("Refusing to extract: %v. Run Aradir without nodownload to download it again.", err)
+				}
+			}
+			manifestChanged = manifestChanged || manifest.Records[i] != val
+
+			// files that aren't archives, like a single plugin from a local step, are left as they are
+			if _, err := FindExtractor(zipPath); err != nil && !PathIncludesArchive(val.FileName) {
+				continue
+			}
+			// two records extracting into one folder would write over each other
+			if sliceContains(jobLocations, location) {
+				continue
+			}
+			step, _ := findDownloadStep(config.DownloadSteps, val)
+			jobs = append(jobs, ExtractJob{Record: manifest.Records[i], ArchivePath: zipPath, Location: location, Nested: step.ExtractNested, Paths: paths, Extracted: extracted})
+			jobLocations = append(jobLocations, location)
+		}
+		if manifestChanged {
+			WriteManifest(&manifest, manifest.ListName)
+		}
+		// the config would point OpenMW at folders that aren't there
+		if len(missing) > 0 {
+			for _, message := range missing {
+				fmt.Println(message)
+			}
+			log.Fatal("Download the missing archives again, the mods can't be installed without them")
+		}
+
+		if err := MeasureExtractJobs(jobs); err != nil {
+			log.Fatal(err)
+		}
+		if err := CheckDiskSpace(jobs, modInstallFolder); err != nil {
+			log.Fatal(err)
+		}
+		if errs := RunExtraction(jobs, prefs.ParallelExtractions); len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Println(err.Error())
 			}
+			log.Fatalf("%d of %d archives could not be extracted", len(errs), len(jobs))
 		}
 	}
 
@@ -431,54 +412,79 @@ func UnpackMods(config ModListConfig, manifest ManifestListConfig, downloadFolde
 	}
 	currentDirectory = strings.Replace(currentDirectory, "\\", "/", -1)
 	currentPresetPath := fmt.Sprint(currentDirectory, "/presets/", config.Name, "/")
+	// the result is written into the preset folder, or over the user's openmw.cfg
+	presetConfigPath := configPath
 	if USE_PRESET_CONFIGS {
-		configPath = fmt.Sprint(prefs.Settings, "/", "openmw.cfg")
-		newConfigPath := fmt.Sprint(currentPresetPath, "openmw.cfg")
-		if exists, _ := Exists(newConfigPath); exists {
-			e := os.Remove(newConfigPath)
-			if e != nil {
-				log.Fatal(e)
-			}
-		}
-		Copy(prefs.Settings, currentPresetPath, "openmw.cfg")
-		configPath = fmt.Sprint(currentPresetPath, "/openmw.cfg")
+		presetConfigPath = fmt.Sprint(currentPresetPath, "openmw.cfg")
 	}
 
-	configLines, contentLines := LoadBlankConfigArrays(configPath)
+	// openmw.cfg and settings.cfg are edited in memory and written once all steps have run
+	cfg := LoadBaseConfig(prefs)
+	settingsPath := fmt.Sprint(currentPresetPath, "settings.cfg")
+	settings := LoadBaseSettings(prefs)
 
+	// load order is applied before delta plugin merges the content, or at the end
+	loadOrderApplied := false
+	dataOwners := []DataOwner{}
 	for _, step := range config.UnpackSteps {
 		var record = ManifestRecord{}
 		if step.ModId > 0 {
-			record = findFileManifestRecord(manifest.Records, step.ModId, step.FileIndex)
+			record = findFileManifestRecord(manifest.Records, config.DownloadSteps, step.ModId, step.FileIndex)
 		}
 		switch step.Type {
 		case DATA:
-			UnpackDataStep(step, record, modInstallFolder, configLines, contentLines, currentPresetPath)
-			configLines, contentLines = LoadBlankConfigArrays(configPath)
+			for i, dataPath := range UnpackDataStep(step, record, modInstallFolder, cfg) {
+				dataOwners = append(dataOwners, DataOwner{Path: dataPath, ModId: step.ModId, FileIndex: step.FileIndex, Data: step.Data[i]})
+			}
 		case DATA_DIRECT:
-			UnpackDataDirectStep(step, record, modInstallFolder, configLines, contentLines, currentPresetPath)
-			configLines, contentLines = LoadBlankConfigArrays(configPath)
+			UnpackDataDirectStep(step, cfg)
 		case DEELETE_LIST:
 			UnpackDeleteStep(step, record, modInstallFolder)
 		case DELETE_LIST_BY_FILE:
 			UnpackDeleteByFileStep(step, record, modInstallFolder)
 		case SETTINGS:
-			UnpackSettingsStep(step, record, modInstallFolder, currentPresetPath)
+			UnpackSettingsStep(step, settings)
 		case RESOURCES:
-			// UnpackResourcesStep(step, record, modInstallFolder, configLines, contentLines)
-			// configLines, contentLines = LoadBlankConfigArrays(configPath)
+			// UnpackResourcesStep(step, record, modInstallFolder, cfg)
 		case INSTALL_TO_OMW:
 			UnpackInstallToOMWFolder(step, record, modInstallFolder, currentPresetPath)
 		case DELTA:
 			deltaFolderPath := fmt.Sprint(modInstallFolder, "/DeltaPlugin")
-			AddBaseContent(configPath)
+			if !loadOrderApplied {
+				ApplyLoadOrder(cfg, config, dataOwners)
+				loadOrderApplied = true
+			}
+			AddBaseContent(cfg)
+			// delta plugin reads openmw.cfg from disk
+			writeOpenMWConfig(cfg, presetConfigPath)
 			CreateDeltaPlugin(prefs.Delta, currentPresetPath, deltaFolderPath)
-			AddDeltaContent(configPath, deltaFolderPath)
+			AddDeltaContent(cfg, deltaFolderPath)
 		case CONTENT:
-			UnpackContentStep(configPath, step.Data)
-			configLines, contentLines = LoadBlankConfigArrays(configPath)
+			UnpackContentStep(cfg, step.Data)
+		case GROUNDCOVER:
+			UnpackGroundcoverStep(step, cfg)
+		case FALLBACK_ARCHIVE:
+			UnpackFallbackArchiveStep(step, cfg)
+		case ENCODING:
+			UnpackEncodingStep(step, cfg)
 		default:
 			log.Fatal("Unknown instruction type.")
 		}
 	}
+
+	if !loadOrderApplied {
+		ApplyLoadOrder(cfg, config, dataOwners)
+	}
+	writeOpenMWConfig(cfg, presetConfigPath)
+	err = settings.Write(settingsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writeOpenMWConfig(cfg *OpenMWConfig, path string) {
+	err := cfg.Write(path)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
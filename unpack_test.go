@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBaseSettingsIgnoresLastRun(t *testing.T) {
+	dir := inTempDir(t)
+	userFolder := filepath.Join(dir, "OpenMW")
+	os.MkdirAll(userFolder, os.ModePerm)
+	os.WriteFile(filepath.Join(userFolder, "settings.cfg"), []byte("[Video]\nresolution x = 1920\n"), 0644)
+	// written by a run that had an option on
+	os.MkdirAll(filepath.Join(dir, "presets", "test"), os.ModePerm)
+	os.WriteFile(filepath.Join(dir, "presets", "test", "settings.cfg"), []byte("[Shaders]\nforce shaders = true\n"), 0644)
+
+	settings := LoadBaseSettings(PreferencesConfig{Settings: userFolder})
+	if value, ok := settings.Get("Video", "resolution x"); !ok || value != "1920" {
+		t.Errorf("resolution x = %q, %v, want the user's 1920", value, ok)
+	}
+	if _, ok := settings.Get("Shaders", "force shaders"); ok {
+		t.Error("a key of the last run was kept")
+	}
+
+	empty := LoadBaseSettings(PreferencesConfig{Settings: filepath.Join(dir, "missing")})
+	if len(empty.Entries) != 0 {
+		t.Errorf("entries = %v, want none without a settings.cfg", empty.Entries)
+	}
+}
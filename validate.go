@@ -0,0 +1,432 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var UNPACK_TYPES = []string{DATA, DATA_DIRECT, CONTENT, SETTINGS, RESOURCES, DEELETE_LIST, DELETE_LIST_BY_FILE, INSTALL_TO_OMW, DELTA, GROUNDCOVER, FALLBACK_ARCHIVE, ENCODING}
+
+// openmw.cfg keys that have their own instruction type
+var DATA_DIRECT_KEYS = map[string]string{CFG_GROUNDCOVER: GROUNDCOVER, CFG_FALLBACK_ARCHIVE: FALLBACK_ARCHIVE, CFG_ENCODING: ENCODING}
+var DOWNLOAD_TYPES = []string{NEXUS, URL, GITHUB_RELEASE, LOCAL}
+
+// unpack types that read from an extracted archive and need a matching download step
+var ARCHIVE_UNPACK_TYPES = []string{DATA, RESOURCES, DEELETE_LIST, DELETE_LIST_BY_FILE, INSTALL_TO_OMW}
+
+type PresetIssue struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (issue PresetIssue) String() string {
+	return fmt.Sprintf("%s:%d: %s", issue.File, issue.Line, issue.Message)
+}
+
+// mappingValue returns the value node stored under key in a yaml mapping node
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// fieldLine is the line of a field inside a step, falling back to the step itself
+func fieldLine(node *yaml.Node, key string) int {
+	keyNode, _ := mappingValue(node, key)
+	if keyNode != nil {
+		return keyNode.Line
+	}
+	if node != nil {
+		return node.Line
+	}
+	return 0
+}
+
+func nodeAt(nodes []*yaml.Node, index int) *yaml.Node {
+	if index < len(nodes) {
+		return nodes[index]
+	}
+	return nil
+}
+
+func sequenceItems(root *yaml.Node, key string) []*yaml.Node {
+	_, seq := mappingValue(root, key)
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		return []*yaml.Node{}
+	}
+	return seq.Content
+}
+
+// ValidatePreset reads a preset from the presets folder and reports every problem found in it
+func ValidatePreset(fileName string) []PresetIssue {
+	path := GetPresetPath(fileName)
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return []PresetIssue{{File: path, Message: err.Error()}}
+	}
+	return ValidatePresetSource(path, file)
+}
+
+// ValidateGeneratedConfig checks plugin masters against the openmw.cfg of the last install, if there is one.
+// It is only run by `mw-aradir validate`, a broken install is fixed by installing again.
+func ValidateGeneratedConfig(fileName string) []PresetIssue {
+	presetName := strings.Replace(fileName, ".yaml", "", 1)
+	path := fmt.Sprint("./", "presets/", presetName, "/openmw.cfg")
+	if exists, _ := Exists(path); !exists {
+		return []PresetIssue{}
+	}
+	cfg, err := ReadOpenMWConfig(path)
+	if err != nil {
+		return []PresetIssue{{File: path, Message: err.Error()}}
+	}
+
+	issues := []PresetIssue{}
+	masters, problems := ReadContentMasters(cfg)
+	problems = append(problems, CheckContentMasters(cfg.Values(CFG_CONTENT), masters)...)
+	for _, problem := range problems {
+		line := cfg.Index(CFG_CONTENT, problem.Plugin) + 1
+		issues = append(issues, PresetIssue{File: path, Line: line, Message: problem.String()})
+	}
+	return issues
+}
+
+func ValidatePresetSource(path string, source []byte) []PresetIssue {
+	var document yaml.Node
+	if err := yaml.Unmarshal(source, &document); err != nil {
+		return []PresetIssue{{File: path, Message: err.Error()}}
+	}
+	if len(document.Content) == 0 {
+		return []PresetIssue{{File: path, Message: "preset is empty"}}
+	}
+	root := document.Content[0]
+
+	issues := []PresetIssue{}
+	addIssue := func(line int, format string, args ...any) {
+		issues = append(issues, PresetIssue{File: path, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	config := ModListConfig{}
+	if err := root.Decode(&config); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for _, msg := range typeErr.Errors {
+				addIssue(0, "%s", msg)
+			}
+		} else {
+			addIssue(root.Line, "%s", err.Error())
+		}
+	}
+
+	if config.Name == "" {
+		addIssue(root.Line, "preset has no name")
+	}
+
+	downloadNodes := sequenceItems(root, "downloadSteps")
+	unpackNodes := sequenceItems(root, "unpackSteps")
+
+	// derived presets are checked against the merged download list
+	downloads := config.DownloadSteps
+	unpacks := config.UnpackSteps
+	options := config.Options
+	loadOrder := config.LoadOrder
+	if config.Extends != "" {
+		base, err := resolvePreset(config.Extends, []string{config.Name})
+		if err != nil {
+			addIssue(fieldLine(root, "extends"), "%s", err.Error())
+		} else {
+			merged, mergeErrs := MergePresets(base, config)
+			for _, mergeErr := range mergeErrs {
+				nodes := downloadNodes
+				if mergeErr.Section == "unpackSteps" {
+					nodes = unpackNodes
+				}
+				addIssue(fieldLine(nodeAt(nodes, mergeErr.Index), "remove"), "%s", mergeErr.Message)
+			}
+			downloads = merged.DownloadSteps
+			unpacks = merged.UnpackSteps
+			options = merged.Options
+			loadOrder = merged.LoadOrder
+		}
+	}
+
+	optionNodes := sequenceItems(root, "options")
+	for i, option := range config.Options {
+		node := nodeAt(optionNodes, i)
+		if strings.TrimSpace(option.Name) == "" || strings.ContainsAny(option.Name, "! ") {
+			addIssue(fieldLine(node, "name"), "option name %q must be a single word without !", option.Name)
+		}
+		for _, other := range config.Options[:i] {
+			if other.Name == option.Name {
+				addIssue(fieldLine(node, "name"), "option %q is declared twice", option.Name)
+			}
+		}
+	}
+
+	values := ResolveOptionValues(options, map[string]bool{})
+	checkCondition := func(node *yaml.Node, condition string) {
+		if _, err := EvaluateCondition(condition, values); err != nil {
+			addIssue(fieldLine(node, "if"), "%s", err.Error())
+		}
+	}
+
+	for i, step := range config.DownloadSteps {
+		node := nodeAt(downloadNodes, i)
+		if step.Remove {
+			continue
+		}
+		checkCondition(node, step.If)
+		if !sliceContains(DOWNLOAD_TYPES, step.Type) {
+			addIssue(fieldLine(node, "type"), "download step has unknown type %q", step.Type)
+		}
+		if step.ModId <= 0 {
+			addIssue(fieldLine(node, "modId"), "download step has invalid modId %d", step.ModId)
+		}
+		if strings.TrimSpace(step.SiteFileName) == "" {
+			addIssue(fieldLine(node, "siteFileName"), "download step for mod %d has an empty siteFileName", step.ModId)
+		}
+		for _, missing := range missingSourceFields(step) {
+			addIssue(fieldLine(node, "type"), "%s download step for mod %d needs %s", step.Type, step.ModId, missing)
+		}
+		if step.FileId != 0 && (step.Type != NEXUS || step.FileId < 0) {
+			addIssue(fieldLine(node, "fileId"), "fileId %d only applies to nexus steps and has to be positive", step.FileId)
+		}
+		if step.Version != "" && step.Type != NEXUS {
+			addIssue(fieldLine(node, "version"), "version only applies to nexus steps")
+		}
+		if step.Sha256 != "" && !isSha256(step.Sha256) {
+			addIssue(fieldLine(node, "sha256"), "sha256 %q is not 64 hex characters", step.Sha256)
+		}
+		if step.Size < 0 {
+			addIssue(fieldLine(node, "size"), "download step has negative size %d", step.Size)
+		}
+	}
+
+	for i, step := range config.UnpackSteps {
+		node := nodeAt(unpackNodes, i)
+		if step.Remove {
+			continue
+		}
+		checkCondition(node, step.If)
+		for _, issue := range validateUnpackStep(step, downloads) {
+			addIssue(fieldLine(node, issue.field), "%s", issue.message)
+		}
+	}
+
+	// groundcover plugins are loaded on their own and can't be content as well
+	groundcover := []string{}
+	for _, step := range config.UnpackSteps {
+		if step.Type == GROUNDCOVER {
+			groundcover = append(groundcover, step.Data...)
+		}
+	}
+	for i, step := range config.UnpackSteps {
+		if step.Type != CONTENT {
+			continue
+		}
+		for _, plugin := range step.Data {
+			if sliceContains(groundcover, plugin) {
+				addIssue(fieldLine(nodeAt(unpackNodes, i), "data"), "%s is listed as both content and groundcover", plugin)
+			}
+		}
+	}
+
+	// the last step wins a conflict, so the preset still installs, conflicts are only reported
+	for _, conflict := range FindSettingsConflicts(config.UnpackSteps) {
+		warning := PresetIssue{File: path, Line: fieldLine(nodeAt(unpackNodes, conflict.StepIndex), "data"), Message: conflict.String()}
+		fmt.Println(fmt.Sprint("Warning: ", warning.String()))
+	}
+
+	issues = append(issues, validateLoadOrder(path, root, config.LoadOrder, loadOrder, downloads, unpacks)...)
+
+	return issues
+}
+
+// validateLoadOrder checks that the preset's own rules name mods and plugins it installs,
+// and that the merged rules can all hold at once
+func validateLoadOrder(path string, root *yaml.Node, own LoadOrderRules, rules LoadOrderRules, downloads []DownloadStep, unpacks []UnpackStep) []PresetIssue {
+	issues := []PresetIssue{}
+	_, loadOrderNode := mappingValue(root, "loadOrder")
+	loadOrderLine := fieldLine(root, "loadOrder")
+	addIssue := func(line int, format string, args ...any) {
+		issues = append(issues, PresetIssue{File: path, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	modIds := []string{}
+	for _, download := range downloads {
+		if id := fmt.Sprint(download.ModId); !sliceContains(modIds, id) {
+			modIds = append(modIds, id)
+		}
+	}
+	// the DATA steps stand in for the data directories, named by mod id and path
+	owners := []DataOwner{}
+	ownerPaths := []string{}
+	for _, step := range unpacks {
+		if step.Type != DATA {
+			continue
+		}
+		for _, data := range step.Data {
+			path := fmt.Sprint(step.ModId, ":", data)
+			owners = append(owners, DataOwner{Path: path, ModId: step.ModId, FileIndex: step.FileIndex, Data: data})
+			ownerPaths = append(ownerPaths, path)
+		}
+	}
+	modNodes := sequenceItems(loadOrderNode, "mods")
+	for i, rule := range own.Mods {
+		node := nodeAt(modNodes, i)
+		for _, modId := range append(append([]int32{rule.Mod}, rule.Before...), rule.After...) {
+			if !sliceContains(modIds, fmt.Sprint(modId)) {
+				addIssue(fieldLine(node, "mod"), "load order rule names mod %d, which has no download step", modId)
+			}
+		}
+		if (rule.Data != "" || rule.FileIndex != nil) && len(ownedDataPaths(owners, rule)) == 0 {
+			addIssue(fieldLine(node, "mod"), "load order rule of mod %d selects no DATA step", rule.Mod)
+		}
+	}
+
+	plugins := append([]string{}, CONTENT_FILTERS...)
+	for _, step := range unpacks {
+		if step.Type == CONTENT {
+			plugins = append(plugins, step.Data...)
+		}
+	}
+	pluginNodes := sequenceItems(loadOrderNode, "plugins")
+	for i, rule := range own.Plugins {
+		node := nodeAt(pluginNodes, i)
+		for _, plugin := range append(append([]string{rule.Plugin}, rule.Before...), rule.After...) {
+			if !containsFold(plugins, plugin) {
+				addIssue(fieldLine(node, "plugin"), "load order rule names %s, which no CONTENT step loads", plugin)
+			}
+		}
+	}
+
+	if _, cycle := SortByRules(ownerPaths, ModOrderEdges(rules.Mods, owners)); len(cycle) > 0 {
+		addIssue(loadOrderLine, "mod load order rules form a cycle between %s", strings.Join(cycle, ", "))
+	}
+	if _, cycle := SortByRules(plugins, PluginOrderEdges(rules.Plugins)); len(cycle) > 0 {
+		addIssue(loadOrderLine, "plugin load order rules form a cycle between %s", strings.Join(cycle, ", "))
+	}
+
+	return issues
+}
+
+// missingSourceFields lists the fields a download step's type needs but doesn't have
+func missingSourceFields(step DownloadStep) []string {
+	missing := []string{}
+	switch step.Type {
+	case URL:
+		if step.Url == "" {
+			missing = append(missing, "url")
+		} else if parsed, err := url.Parse(step.Url); err != nil || parsed.Host == "" {
+			missing = append(missing, "an absolute url")
+		}
+	case GITHUB_RELEASE:
+		if len(strings.Split(step.Repo, "/")) != 2 {
+			missing = append(missing, "repo as owner/name")
+		}
+		if step.Asset == "" {
+			missing = append(missing, "asset")
+		} else if _, err := filepath.Match(step.Asset, ""); err != nil {
+			missing = append(missing, "a valid asset pattern")
+		}
+	case LOCAL:
+		if step.Path == "" {
+			missing = append(missing, "path")
+		} else if exists, _ := Exists(getLocalSourcePath(step.Path)); !exists {
+			missing = append(missing, fmt.Sprintf("path %s to exist", step.Path))
+		}
+	}
+	return missing
+}
+
+type stepIssue struct {
+	field   string
+	message string
+}
+
+func validateUnpackStep(step UnpackStep, downloads []DownloadStep) []stepIssue {
+	issues := []stepIssue{}
+	addIssue := func(field string, format string, args ...any) {
+		issues = append(issues, stepIssue{field: field, message: fmt.Sprintf(format, args...)})
+	}
+
+	if !sliceContains(UNPACK_TYPES, step.Type) {
+		addIssue("type", "unpack step has unknown type %q", step.Type)
+	}
+
+	if step.ModId > 0 {
+		count := getDownloadCount(downloads, step.ModId)
+		if count == 0 {
+			addIssue("modId", "unpack step refers to mod %d, which has no download step", step.ModId)
+		} else if step.FileIndex < 0 || int(step.FileIndex) >= count {
+			addIssue("fileIndex", "unpack step for mod %d uses fileIndex %d, but only %d download step(s) exist", step.ModId, step.FileIndex, count)
+		}
+	} else if sliceContains(ARCHIVE_UNPACK_TYPES, step.Type) {
+		addIssue("modId", "%s step needs the modId of a downloaded archive", step.Type)
+	}
+
+	switch step.Type {
+	case INSTALL_TO_OMW:
+		if len(step.Data)%2 != 0 {
+			addIssue("data", "%s step needs source/destination pairs, got %d value(s)", step.Type, len(step.Data))
+		}
+	case DATA_DIRECT:
+		for _, line := range step.Data {
+			entry := ParseConfigLine(line)
+			if !entry.IsSetting() {
+				addIssue("data", "%s line %q is not a key=value pair", step.Type, line)
+			} else if instruction, ok := DATA_DIRECT_KEYS[entry.Key]; ok {
+				addIssue("data", "%s line %q should use a %s step", step.Type, line, instruction)
+			}
+		}
+	case GROUNDCOVER:
+		for _, plugin := range step.Data {
+			if !hasExts(plugin, PLUGIN_EXTS) {
+				addIssue("data", "%s plugin %q is not an .esp, .esm or .omwaddon file", step.Type, plugin)
+			}
+		}
+	case FALLBACK_ARCHIVE:
+		for _, archive := range step.Data {
+			if !hasExts(archive, []string{".bsa"}) {
+				addIssue("data", "%s archive %q is not a .bsa file", step.Type, archive)
+			}
+		}
+	case ENCODING:
+		if len(step.Data) != 1 || !sliceContains(ENCODINGS, step.Data[0]) {
+			addIssue("data", "%s step needs exactly one of %s", step.Type, strings.Join(ENCODINGS, ", "))
+		}
+	case SETTINGS:
+		if _, err := ParseSettingsStep(step.Data); err != nil {
+			addIssue("data", "%s step: %s", step.Type, err.Error())
+		}
+	case DELETE_LIST_BY_FILE:
+		for _, dataPath := range step.Data {
+			exists, err := Exists(fmt.Sprint("./", dataPath))
+			if err != nil {
+				addIssue("data", "%s", err.Error())
+			} else if !exists {
+				addIssue("data", "%s file %q does not exist", step.Type, dataPath)
+			}
+		}
+	case CONTENT:
+		for _, plugin := range step.Data {
+			if strings.TrimSpace(plugin) == "" {
+				addIssue("data", "%s step has an empty plugin name", step.Type)
+			} else if strings.Contains(plugin, "=") {
+				addIssue("data", "%s plugin %q looks like an openmw.cfg line", step.Type, plugin)
+			}
+		}
+	}
+
+	return issues
+}